@@ -0,0 +1,122 @@
+package airbrake
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/batch"
+	"github.com/airbrake/gobrake"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrQueueFull is reported to the error handler for the notices fired
+	// while the queue of an asynchronous hook is full.
+	ErrQueueFull = errors.New("airbrake: queue is full (notice is dropped)")
+	// ErrClosed is reported to the error handler for the notices fired after
+	// Close was called.
+	ErrClosed = errors.New("airbrake: hook is closed")
+	// ErrFlushTimeout is returned by FlushTimeout when the timeout elapses before
+	// all notices were sent.
+	ErrFlushTimeout = errors.New("airbrake: flush timed out")
+)
+
+// AsyncConfig configures an asynchronous hook.
+type AsyncConfig struct {
+	// Workers is the number of notices sent concurrently. Defaults to 1.
+	Workers int
+	// QueueSize is the number of notices queued for the workers, besides
+	// the one being handed to a worker; notices fired while the queue is
+	// full are dropped. Defaults to 100.
+	QueueSize int
+	// MaxRetryWait is the longest back-off a worker waits out to send a
+	// notice again that the server rejected with a rate limit; notices
+	// facing a longer one fail. Defaults to 10 seconds, and a negative
+	// value disables the retry.
+	MaxRetryWait time.Duration
+}
+
+// NewAsyncHook creates a hook same as NewHook, but in asynchronous mode:
+// Fire queues the notice and returns, and the workers send it in the
+// background. The notices are queued on a batch.Batcher, in batches of one
+// as Airbrake takes a notice per request. Call Flush or Close before the
+// program exits so that the queued notices are not lost.
+func NewAsyncHook(projectID int64, apiKey, env string, cfg AsyncConfig) *Hook {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.MaxRetryWait == 0 {
+		cfg.MaxRetryWait = 10 * time.Second
+	}
+	hook := NewHook(projectID, apiKey, env)
+	hook.retryWait = cfg.MaxRetryWait
+	hook.batcher = batch.New(hook.Name, batch.Config{
+		MaxEntries: 1,
+		Workers:    cfg.Workers,
+		QueueSize:  cfg.QueueSize,
+	}, nil, hook.sendItems)
+	// the hook reports the outcome of its notices itself
+	hook.batcher.SetErrorHandler(nil)
+	return hook
+}
+
+// enqueue queues notice for the workers, or drops it when the queue is full
+// or the hook is closed.
+func (hook *Hook) enqueue(entry *logrus.Entry, notice *gobrake.Notice) {
+	switch hook.batcher.AddItem(batch.Item{Entry: batch.Snapshot(entry), Value: notice}) {
+	case batch.ErrQueueFull:
+		hook.drop(notice, ErrQueueFull)
+	case batch.ErrClosed:
+		hook.drop(notice, ErrClosed)
+	}
+}
+
+// drop counts notice as dropped and reports err to the error handler.
+func (hook *Hook) drop(notice *gobrake.Notice, err error) {
+	hook.stats.Dropped()
+	if hook.errorHandler != nil {
+		hook.errorHandler.HandleError(hook.Name, notice, err)
+	}
+}
+
+// sendItems is the batch.Sender of an asynchronous hook. The outcome of the
+// notices is recorded by send, so no error is handed back to the batcher.
+func (hook *Hook) sendItems(items []batch.Item) []error {
+	for _, item := range items {
+		hook.verify(item.Value.(*gobrake.Notice))
+	}
+	return nil
+}
+
+// Flush waits until every queued notice was sent. It only does anything in
+// asynchronous mode.
+func (hook *Hook) Flush() {
+	if hook.batcher == nil {
+		return
+	}
+	hook.batcher.Flush(time.Duration(math.MaxInt64))
+}
+
+// FlushTimeout waits until every queued notice was sent or timeout elapses,
+// and returns ErrFlushTimeout in the latter case. It only does anything in
+// asynchronous mode.
+func (hook *Hook) FlushTimeout(timeout time.Duration) error {
+	if hook.batcher == nil {
+		return nil
+	}
+	if err := hook.batcher.Flush(timeout); err != nil {
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
+// Close stops accepting notices, sends the queued ones and waits for the
+// workers to finish. It only does anything in asynchronous mode; the
+// notices fired afterwards are dropped.
+func (hook *Hook) Close() error {
+	if hook.batcher == nil {
+		return nil
+	}
+	return hook.batcher.Close()
+}
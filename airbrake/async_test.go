@@ -0,0 +1,184 @@
+package airbrake
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingRoundTripper signals every request on started and accepts it once
+// release is closed.
+type blockingRoundTripper struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingRoundTripper() *blockingRoundTripper {
+	return &blockingRoundTripper{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (rt *blockingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.started <- struct{}{}
+	<-rt.release
+	return &http.Response{
+		StatusCode: http.StatusCreated,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"id":"1"}`)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func newAsyncTestHook(rt http.RoundTripper, cfg AsyncConfig) (*Hook, *errhandler.Counter) {
+	hook := NewAsyncHook(projectID, testAPIKey, "production", cfg)
+	hook.Airbrake.Client = &http.Client{Transport: rt}
+	counter := &errhandler.Counter{}
+	hook.SetErrorHandler(counter)
+	return hook, counter
+}
+
+func TestAsyncHook(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	rt := newBlockingRoundTripper()
+	hook, counter := newAsyncTestHook(rt, AsyncConfig{QueueSize: 1})
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+
+	log.Error(expectedMsg)
+	select {
+	case <-rt.started:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out; the notice was not sent")
+	}
+	// the next notice waits to be handed to the busy worker
+	log.Error(expectedMsg)
+	for deadline := time.Now().Add(time.Second); hook.Health().QueueSaturation > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out; the notice was not taken off the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	log.Error(expectedMsg)
+	log.Error(expectedMsg)
+
+	assert.Equal(t, int64(1), counter.Count("airbrake"), "the notice fired on a full queue should be dropped")
+	assert.Equal(t, uint64(1), hook.Stats().Dropped)
+	assert.Equal(t, 1.0, hook.Health().QueueSaturation)
+	assert.Equal(t, int64(2), hook.Stats().QueueDepth, "the waiting notices should not count as in flight")
+	assert.Equal(t, int64(1), hook.Stats().InFlight)
+	assert.Equal(t, ErrFlushTimeout, hook.FlushTimeout(10*time.Millisecond))
+
+	close(rt.release)
+	assert.NoError(t, hook.FlushTimeout(time.Second))
+	assert.Equal(t, uint64(3), hook.Stats().Sent)
+	assert.Equal(t, int64(0), hook.Stats().InFlight)
+	assert.Equal(t, int64(0), hook.Stats().QueueDepth)
+	assert.Equal(t, 0.0, hook.Health().QueueSaturation)
+}
+
+func TestAsyncWorkers(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	rt := newBlockingRoundTripper()
+	hook, _ := newAsyncTestHook(rt, AsyncConfig{Workers: 2})
+	defer hook.Close()
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+
+	log.Error(expectedMsg)
+	log.Error(expectedMsg)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-rt.started:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out; the notices should be sent concurrently")
+		}
+	}
+	close(rt.release)
+	hook.Flush()
+	assert.Equal(t, uint64(2), hook.Stats().Sent)
+}
+
+func TestAsyncClose(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	rt := newBlockingRoundTripper()
+	close(rt.release)
+	hook, counter := newAsyncTestHook(rt, AsyncConfig{})
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(hook)
+
+	for i := 0; i < 3; i++ {
+		log.Error(expectedMsg)
+	}
+	assert.NoError(t, hook.Close())
+	assert.Equal(t, uint64(3), hook.Stats().Sent, "Close should send the queued notices")
+
+	log.Error(expectedMsg)
+	assert.Equal(t, int64(1), counter.Count("airbrake"))
+	assert.Equal(t, uint64(1), hook.Stats().Dropped)
+	assert.NoError(t, hook.Close())
+	assert.NoError(t, hook.FlushTimeout(time.Second))
+}
+
+func TestSyncFireError(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	hook := NewHook(projectID, testAPIKey, "production")
+	hook.Airbrake.Client = &http.Client{Transport: &failingRoundTripper{}}
+	hook.SetErrorHandler(&errhandler.Counter{})
+
+	err := hook.Fire(logrus.NewEntry(logrus.New()).WithField("k", "v"))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), hook.Stats().Failed)
+
+	assert.NoError(t, hook.FlushTimeout(time.Second), "Flush should do nothing in synchronous mode")
+	assert.NoError(t, hook.Close())
+}
+
+func TestAsyncRetryAfterRateLimit(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	for name, tc := range map[string]struct {
+		cfg      AsyncConfig
+		requests int
+		retried  uint64
+		sent     uint64
+	}{
+		"retried":  {cfg: AsyncConfig{}, requests: 2, retried: 1, sent: 1},
+		"disabled": {cfg: AsyncConfig{MaxRetryWait: -1}, requests: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := &rateLimitedRoundTripper{header: http.Header{"Retry-After": []string{"0.05"}}}
+			hook := NewAsyncHook(projectID, testAPIKey, "production", tc.cfg)
+			hook.Airbrake.Client = &http.Client{Transport: hook.Transport(rt)}
+			counter := &errhandler.Counter{}
+			hook.SetErrorHandler(counter)
+			log := logrus.New()
+			log.Out = ioutil.Discard
+			log.Hooks.Add(hook)
+
+			log.Error(expectedMsg)
+			assert.NoError(t, hook.FlushTimeout(time.Second))
+			assert.Equal(t, tc.requests, rt.requests)
+			s := hook.Stats()
+			assert.Equal(t, tc.retried, s.Retried)
+			assert.Equal(t, tc.sent, s.Sent)
+			assert.Equal(t, 1-tc.sent, s.Failed)
+			assert.Equal(t, int64(1-tc.sent), counter.Count("airbrake"))
+		})
+	}
+}
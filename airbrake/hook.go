@@ -2,11 +2,24 @@ package airbrake
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
-	"os"
+	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/CIP-NL/logrus-hooks/backoff"
+	"github.com/CIP-NL/logrus-hooks/batch"
+	"github.com/CIP-NL/logrus-hooks/buildinfo"
+	"github.com/CIP-NL/logrus-hooks/callers"
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/errchain"
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/fieldfmt"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/CIP-NL/logrus-hooks/tracing"
 	"github.com/airbrake/gobrake"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,57 +27,430 @@ import (
 // with the Airbrake API.
 type Hook struct {
 	Airbrake *gobrake.Notifier
+	// Name identifies the hook to the error handler. Defaults to "airbrake".
+	Name string
+	// BeforeSend, when set, is called with every notice built from an
+	// entry before it is sent, and may modify it or return a different one.
+	// Returning nil drops the event. The filters of the notifier run after
+	// BeforeSend.
+	BeforeSend func(entry *logrus.Entry, notice *gobrake.Notice) *gobrake.Notice
+
+	// ContextFields maps the fields sent as context keys of the notices to
+	// their key; the other fields are sent as params. Defaults to a copy of
+	// DefaultContextFields. The keys set by the hook and the notifier, such
+	// as environment, version and hostname, cannot be mapped to.
+	ContextFields map[string]string
+
+	errorHandler errhandler.ErrorHandler
+	stats        stats.Recorder
+	backoff      backoff.Backoff
+	// batcher queues the notices in asynchronous mode, see NewAsyncHook.
+	batcher   *batch.Batcher
+	retryWait time.Duration
+
+	environment string
+	release     string
+	serverName  string
+}
+
+// DefaultContextFields are the fields sent as context keys by default, under
+// their own name: user, component, action, url and route, and the method,
+// code, peer, target and deadline of the calls logged by the grpclogger
+// interceptors. The component defaults to the package of the call site, and
+// the url and route to those of the request of the entry.
+var DefaultContextFields = map[string]string{
+	"user":          "user",
+	"component":     "component",
+	"action":        "action",
+	"url":           "url",
+	"route":         "route",
+	"grpc.method":   "grpc.method",
+	"grpc.code":     "grpc.code",
+	"grpc.peer":     "grpc.peer",
+	"grpc.target":   "grpc.target",
+	"grpc.deadline": "grpc.deadline",
+}
+
+// reservedContextKeys are the context keys set by the hook or the notifier.
+var reservedContextKeys = map[string]bool{
+	"environment":   true,
+	"version":       true,
+	"revision":      true,
+	"hostname":      true,
+	"rootDirectory": true,
+	"gopath":        true,
+	"notifier":      true,
+	"language":      true,
+	"os":            true,
+	"architecture":  true,
 }
 
-// NewHook returns a new Airbrake hook given the projectID, apiKey and environment
+// httpStatusEnhanceYourCalm is the status Airbrake answers with when the
+// account is rate limited.
+const httpStatusEnhanceYourCalm = 420
+
+// NewHook returns a new Airbrake hook given the projectID, apiKey and environment.
+// An empty environment defaults to buildinfo.Environment. Notices carry the
+// release and VCS revision of the program, see buildinfo.Release and
+// buildinfo.Revision.
 func NewHook(projectID int64, apiKey, env string) *Hook {
-	airbrake := gobrake.NewNotifier(projectID, apiKey)
+	airbrake := gobrake.NewNotifierWithOptions(&gobrake.NotifierOptions{
+		ProjectId:  projectID,
+		ProjectKey: apiKey,
+		Revision:   buildinfo.Revision(),
+	})
+	if env == "" {
+		env = buildinfo.Environment()
+	}
+	contextFields := make(map[string]string, len(DefaultContextFields))
+	for k, v := range DefaultContextFields {
+		contextFields[k] = v
+	}
+	hook := &Hook{
+		Airbrake:      airbrake,
+		Name:          "airbrake",
+		ContextFields: contextFields,
+		errorHandler:  errhandler.Stderr,
+		environment:   env,
+		release:       buildinfo.Release(),
+	}
 	airbrake.AddFilter(func(notice *gobrake.Notice) *gobrake.Notice {
-		if env == "development" {
+		if hook.environment == "development" {
 			return nil
 		}
-		notice.Context["environment"] = env
+		notice.Context["environment"] = hook.environment
+		if hook.release != "" {
+			notice.Context["version"] = hook.release
+		}
+		if hook.serverName != "" {
+			notice.Context["hostname"] = hook.serverName
+		}
 		return notice
 	})
-	hook := &Hook{
-		Airbrake: airbrake,
-	}
+	client := *airbrake.Client
+	client.Transport = hook.Transport(client.Transport)
+	airbrake.Client = &client
 	return hook
 }
 
-// Fire sends the entry to airbrake using the hook
+// Transport wraps next so that the hook backs off when a response received
+// through it rate limits the project (429 or 420), for the delay given by
+// its X-RateLimit-Delay or Retry-After header. The response is turned into
+// an error, so the notifier does not keep a back-off of its own next to the
+// one of the hook. NewHook installs it on the client of the notifier; wrap
+// the transport of a replacement client with it as well.
+func (hook *Hook) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &backoffTransport{next: next, backoff: &hook.backoff}
+}
+
+type backoffTransport struct {
+	next    http.RoundTripper
+	backoff *backoff.Backoff
+}
+
+func (t *backoffTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == httpStatusEnhanceYourCalm {
+		delay := backoff.RetryAfter(res.Header)
+		if seconds, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Delay"), 10, 64); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		}
+		t.backoff.Limit(backoff.All, delay)
+		res.Body.Close()
+		return nil, &backoff.RejectedError{Status: res.StatusCode, Until: t.backoff.Until(backoff.All)}
+	}
+	return res, nil
+}
+
+// Fire sends the entry to airbrake using the hook. The fields extracted from
+// entry.Context, see ctxfields, are sent along with those of the entry.
+//
+// In synchronous mode Fire returns the error the notice failed to be
+// delivered with, if any; notices dropped while the project is rate limited
+// are not errors. In asynchronous mode Fire queues the notice and returns
+// nil, and delivery failures only reach the error handler.
 func (hook *Hook) Fire(entry *logrus.Entry) error {
+	defer func(start time.Time) { hook.stats.ObserveFire(time.Since(start)) }(time.Now())
+	data := ctxfields.Merge(entry)
 	var notifyErr error
-	err, ok := entry.Data["error"].(error)
+	err, ok := data["error"].(error)
 	if ok {
 		notifyErr = err
 	} else {
 		notifyErr = errors.New(entry.Message)
 	}
-	var req *http.Request
-	for k, v := range entry.Data {
-		if r, ok := v.(*http.Request); ok {
-			req = r
-			delete(entry.Data, k)
-			break
+	reqKey, req := requestOf(data)
+	httpReq, _ := req.(*http.Request)
+	// the backtrace starts at the call site of the entry
+	stack, depth := callers.Stack(entry)
+	notice := hook.Airbrake.Notice(notifyErr, httpReq, depth)
+	// the type of the logged error, naming the original type of a redacted
+	// one; the types of its causes are those of the chained errors below
+	notice.Errors[0].Type = errchain.TypeName(notifyErr)
+	switch req := req.(type) {
+	case *http.Request:
+		if req.Pattern != "" {
+			notice.Context["route"] = req.Pattern
+		}
+	case *httpreq.Request:
+		setRequest(notice, req)
+	}
+	if _, ok := notifyErr.(stackTracer); !ok && depth < 0 && len(stack) > 0 {
+		// the call site is not on the stack of this goroutine
+		notice.Errors[0].Backtrace = framesOf(stack)
+	}
+	notice.Errors = append(notice.Errors, causes(notifyErr)...)
+	hook.addFields(notice, data, reqKey)
+	if traceID, spanID, ok := tracing.IDs(entry); ok {
+		notice.Context[tracing.FieldTraceID] = traceID
+		if spanID != "" {
+			notice.Context[tracing.FieldSpanID] = spanID
 		}
 	}
-	notice := hook.Airbrake.Notice(notifyErr, req, 3)
-	for k, v := range entry.Data {
-		notice.Context[k] = fmt.Sprintf("%s", v)
+	if hook.BeforeSend != nil {
+		if notice = hook.BeforeSend(entry, notice); notice == nil {
+			hook.stats.Dropped()
+			return nil
+		}
 	}
 
-	hook.Verify(notice)
+	if hook.batcher != nil {
+		hook.enqueue(entry, notice)
+		return nil
+	}
+	if err := hook.verify(notice); err != backoff.ErrRateLimited {
+		return err
+	}
 	return nil
 }
 
-// Verify checks whether the airbrake service can be used
+// addFields sends the fields mapped by ContextFields as context keys and the
+// other fields, except the error, the trace IDs and the request field reqKey,
+// as params. Without a user field the user_id, user_name and user_email
+// fields make up the user of the context instead.
+func (hook *Hook) addFields(notice *gobrake.Notice, data logrus.Fields, reqKey string) {
+	user := make(map[string]interface{})
+	userParams := make(map[string]interface{})
+	for k, v := range data {
+		switch k {
+		case logrus.ErrorKey, tracing.FieldTraceID, tracing.FieldSpanID, reqKey:
+			continue
+		}
+		if key, ok := hook.ContextFields[k]; ok && !reservedContextKeys[key] {
+			notice.Context[key] = fieldfmt.FormatJSON(v)
+			continue
+		}
+		if key, ok := userFields[k]; ok {
+			user[key] = fieldfmt.FormatJSON(v)
+			userParams[k] = user[key]
+			continue
+		}
+		notice.Params[k] = fieldfmt.FormatJSON(v)
+	}
+	if _, ok := notice.Context["user"]; !ok && len(user) > 0 {
+		notice.Context["user"] = user
+		return
+	}
+	for k, v := range userParams {
+		notice.Params[k] = v
+	}
+}
+
+// userFields are the fields making up the user of the context, mapped to
+// their keys in it.
+var userFields = map[string]string{
+	"user_id":    "id",
+	"user_name":  "name",
+	"user_email": "email",
+}
+
+// requestOf returns the key and value of the field holding the request of an
+// entry: the http_request field, see httpreq.Field, or else the first field
+// holding a *http.Request or an httpreq.Value. The request of a Value is
+// returned as a *http.Request. It returns an empty key when there is none.
+func requestOf(data logrus.Fields) (string, interface{}) {
+	if req, ok := data[httpreq.Field].(*httpreq.Request); ok {
+		return httpreq.Field, req
+	}
+	if req, ok := httpreq.HTTPRequest(data[httpreq.Field]); ok {
+		return httpreq.Field, req
+	}
+	for k, v := range data {
+		if req, ok := httpreq.HTTPRequest(v); ok {
+			return k, req
+		}
+	}
+	return "", nil
+}
+
+// setRequest sets the request context of notice from req, as
+// gobrake.Notice.SetRequest does for a *http.Request.
+func setRequest(notice *gobrake.Notice, req *httpreq.Request) {
+	url := req.URL
+	if req.Query != "" {
+		url += "?" + req.Query
+	}
+	notice.Context["url"] = url
+	notice.Context["httpMethod"] = req.Method
+	if ua := req.Headers["User-Agent"]; ua != "" {
+		notice.Context["userAgent"] = ua
+	}
+	if addr := req.Env["REMOTE_ADDR"]; addr != "" {
+		notice.Context["userAddr"] = addr
+	}
+	for k, v := range req.Headers {
+		notice.Env[k] = v
+	}
+}
+
+// causes returns the errors wrapped by err as Airbrake errors, outermost
+// first. Errors carrying a pkg/errors stack trace get it as their backtrace.
+func causes(err error) []gobrake.Error {
+	links := errchain.Chain(err)
+	if len(links) < 2 {
+		return nil
+	}
+	errs := make([]gobrake.Error, 0, len(links)-1)
+	for _, link := range links[1:] {
+		errs = append(errs, gobrake.Error{
+			Type:      errchain.TypeName(link.Err),
+			Message:   link.Err.Error(),
+			Backtrace: backtrace(link),
+		})
+	}
+	return errs
+}
+
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+// backtrace converts the pkg/errors stack trace of a link, if any.
+func backtrace(link errchain.Link) []gobrake.StackFrame {
+	for _, layer := range link.Layers {
+		tracer, ok := layer.(stackTracer)
+		if !ok {
+			continue
+		}
+		var frames []gobrake.StackFrame
+		for _, f := range tracer.StackTrace() {
+			pc := uintptr(f) - 1
+			fn := runtime.FuncForPC(pc)
+			if fn == nil {
+				continue
+			}
+			file, line := fn.FileLine(pc)
+			frames = append(frames, gobrake.StackFrame{File: file, Line: line, Func: fn.Name()})
+		}
+		return frames
+	}
+	return []gobrake.StackFrame{}
+}
+
+// framesOf converts stack frames to an Airbrake backtrace.
+func framesOf(stack []runtime.Frame) []gobrake.StackFrame {
+	frames := make([]gobrake.StackFrame, 0, len(stack))
+	for _, f := range stack {
+		frames = append(frames, gobrake.StackFrame{File: f.File, Line: f.Line, Func: f.Function})
+	}
+	return frames
+}
+
+// Verify checks whether the airbrake service can be used. While the project
+// is rate limited the notice is dropped and only counted.
 func (hook *Hook) Verify(notice *gobrake.Notice) bool {
-	if _, err := hook.Airbrake.SendNotice(notice); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to send error to Airbrake: %v\n", err)
-		return false
+	return hook.verify(notice) == nil
+}
+
+// verify sends notice, counting it as in flight meanwhile.
+func (hook *Hook) verify(notice *gobrake.Notice) error {
+	hook.stats.AddInFlight(1)
+	defer hook.stats.AddInFlight(-1)
+	return hook.send(notice)
+}
+
+// send sends notice, records the outcome and passes failures on to the
+// error handler. It returns backoff.ErrRateLimited without sending while
+// the project is rate limited. An asynchronous hook sends a notice the
+// server rejected with a rate limit again once the back-off lifts, if it
+// lifts within AsyncConfig.MaxRetryWait.
+func (hook *Hook) send(notice *gobrake.Notice) error {
+	if hook.backoff.Limited(backoff.All) {
+		hook.stats.Dropped()
+		return backoff.ErrRateLimited
+	}
+	id, err := hook.Airbrake.SendNotice(notice)
+	if wait, ok := backoff.RetryWait(err, hook.retryWait); ok {
+		time.Sleep(wait)
+		hook.stats.Retried()
+		id, err = hook.Airbrake.SendNotice(notice)
+	}
+	if err != nil {
+		hook.stats.Failed(err)
+		if hook.errorHandler != nil {
+			hook.errorHandler.HandleError(hook.Name, notice, err)
+		}
+		return err
 	}
-	return true
+	if id == "" {
+		// the notice was ignored by a filter
+		hook.stats.Dropped()
+		return nil
+	}
+	hook.stats.Sent()
+	return nil
+}
+
+// Stats returns a snapshot of the delivery statistics of the hook. The
+// queue depth of an asynchronous hook is that of its batcher.
+func (hook *Hook) Stats() stats.Snapshot {
+	s := hook.stats.Snapshot(hook.Name)
+	if hook.batcher != nil {
+		s.QueueDepth = hook.batcher.Stats().QueueDepth
+	}
+	return s
+}
+
+// Health returns the current delivery health of the hook. The queue
+// saturation of an asynchronous hook is the fill level of its queue. The
+// circuit is open while the project is rate limited.
+func (hook *Hook) Health() stats.Health {
+	h := hook.stats.Health(hook.Name)
+	if !hook.backoff.Until(backoff.All).IsZero() {
+		h.Circuit = stats.CircuitOpen
+	}
+	if hook.batcher != nil {
+		h.QueueSaturation = hook.batcher.Health().QueueSaturation
+	}
+	return h
+}
+
+// SetRelease sets the version notices are reported for.
+func (hook *Hook) SetRelease(release string) {
+	hook.release = release
+}
+
+// SetEnvironment sets the environment notices are reported for. Notices of
+// the "development" environment are not sent.
+func (hook *Hook) SetEnvironment(environment string) {
+	hook.environment = environment
+}
+
+// SetServerName sets the hostname notices are reported from. Defaults to
+// the host name of the machine.
+func (hook *Hook) SetServerName(serverName string) {
+	hook.serverName = serverName
+}
+
+// SetErrorHandler sets the handler delivery failures are reported to.
+func (hook *Hook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	hook.errorHandler = handler
 }
 
 // Levels returns the standard levels for logrus
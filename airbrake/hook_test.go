@@ -2,18 +2,31 @@ package airbrake
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/CIP-NL/logrus-hooks/buildinfo"
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/CIP-NL/logrus-hooks/stats"
 	"github.com/airbrake/gobrake"
 	"github.com/joho/godotenv"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type customErr struct {
@@ -29,6 +42,7 @@ const (
 	expectedClass = "*airbrake.customErr"
 	expectedMsg   = "foo"
 	unintendedMsg = "Airbrake will not see this string"
+	server        = "web-1.internal"
 )
 
 var (
@@ -66,7 +80,7 @@ func init() {
 // method causes an XML payload containing the log entry message is received
 // by a HTTP server emulating an Airbrake-compatible endpoint.
 
-//Unit-tests:
+// Unit-tests:
 func TestLogEntryMessageReceived(t *testing.T) {
 	if integration {
 		t.Skip()
@@ -86,6 +100,7 @@ func TestLogEntryMessageReceived(t *testing.T) {
 	case <-time.After(time.Second):
 		t.Error("Timed out; no notice received by Airbrake API")
 	}
+	assert.Equal(t, uint64(1), hook.Stats().Sent)
 }
 
 // TestLogEntryMessageReceived confirms that, when passing an error type using
@@ -147,6 +162,47 @@ func TestLogEntryWithNonErrorTypeNotReceived(t *testing.T) {
 	}
 }
 
+// TestLogEntryWithWrappedErrorReceived confirms that every error of a wrapped
+// chain is sent with its type and message, outermost first.
+func TestLogEntryWithWrappedErrorReceived(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	hook := newTestHook()
+	log.Hooks.Add(hook)
+
+	root := &customErr{msg: expectedMsg}
+	err := fmt.Errorf("joined: %w", errors.Join(pkgerrors.Wrap(root, "wrapped"), errors.New("other")))
+	log.WithError(err).Error(unintendedMsg)
+
+	select {
+	case received := <-noticeChan:
+		var types, msgs []string
+		for _, e := range received.Errors {
+			types = append(types, e.Type)
+			msgs = append(msgs, e.Message)
+		}
+		assert.Equal(t, []string{
+			"*fmt.wrapError",
+			"*errors.joinError",
+			"*errors.withMessage",
+			expectedClass,
+			"*errors.errorString",
+		}, types, "the first error should name the type of the logged error")
+		assert.Equal(t, []string{
+			"joined: wrapped: foo\nother",
+			"wrapped: foo\nother",
+			"wrapped: foo",
+			expectedMsg,
+			"other",
+		}, msgs)
+		assert.NotEmpty(t, received.Errors[2].Backtrace, "the pkg/errors stack should be sent")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
 func TestLogEntryWithCustomFields(t *testing.T) {
 	if integration {
 		t.Skip()
@@ -165,14 +221,229 @@ func TestLogEntryWithCustomFields(t *testing.T) {
 		if receivedErr.Message != unintendedMsg {
 			t.Errorf("Unexpected message received: %s", receivedErr.Message)
 		}
-		if received.Context["user_id"] != "123" {
-			t.Errorf("Expected message to contain Context[\"user_id\"] == \"123\" got %q", received.Context["user_id"])
+		if user, _ := received.Context["user"].(map[string]interface{}); user["id"] != "123" {
+			t.Errorf("Expected message to contain Context[\"user\"][\"id\"] == \"123\" got %v", received.Context["user"])
+		}
+		if _, ok := received.Params["user_id"]; ok {
+			t.Error("user_id should only be sent in the user of the context")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+func TestBeforeSend(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	hook := newTestHook()
+	hook.BeforeSend = func(entry *logrus.Entry, notice *gobrake.Notice) *gobrake.Notice {
+		if entry.Data["drop"] == true {
+			return nil
 		}
+		notice.Errors[0].Message = "rewritten: " + entry.Message
+		notice.Context["component"] = entry.Data["component"]
+		return notice
+	}
+	log.Hooks.Add(hook)
+
+	log.WithField("drop", true).Error(unintendedMsg)
+	log.WithField("component", "billing").Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "rewritten: "+expectedMsg, received.Errors[0].Message)
+		assert.Equal(t, "billing", received.Context["component"])
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+	assert.Equal(t, uint64(1), hook.Stats().Dropped)
+	assert.Equal(t, uint64(1), hook.Stats().Sent)
+}
+
+func TestTraceContext(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	log.WithContext(ctx).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, traceID.String(), received.Context["trace_id"])
+		assert.Equal(t, spanID.String(), received.Context["span_id"])
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+func TestParams(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	log.WithFields(logrus.Fields{
+		"count":       123,
+		"order":       struct{ ID int }{ID: 7},
+		"cause":       errors.New("timeout"),
+		"callback":    make(chan int),
+		"component":   "billing",
+		"environment": "field",
+		"user_id":     "42",
+		"user_email":  "jane@example.com",
+	}).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, float64(123), received.Params["count"])
+		assert.Equal(t, map[string]interface{}{"ID": float64(7)}, received.Params["order"])
+		assert.Equal(t, "timeout", received.Params["cause"])
+		assert.IsType(t, "", received.Params["callback"])
+		assert.Equal(t, "billing", received.Context["component"])
+		assert.NotContains(t, received.Params, "component")
+		assert.Equal(t, "field", received.Params["environment"])
+		assert.NotEqual(t, "field", received.Context["environment"], "reserved context keys should not be overwritten")
+		assert.Equal(t, map[string]interface{}{"id": "42", "email": "jane@example.com"}, received.Context["user"])
+		assert.NotContains(t, received.Params, "user_id")
+		assert.NotContains(t, received.Params, "user_email")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+func TestUserFieldWins(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	log.WithFields(logrus.Fields{
+		"user":    "jane",
+		"user_id": "42",
+	}).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "jane", received.Context["user"])
+		assert.Equal(t, "42", received.Params["user_id"], "user fields left out of the context should be sent as params")
 	case <-time.After(time.Second):
 		t.Error("Timed out; no notice received by Airbrake API")
 	}
 }
 
+func TestContextFieldsMapping(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	hook := newTestHook()
+	hook.ContextFields["grpc.method"] = "action"
+	hook.ContextFields["env"] = "environment"
+	log.Hooks.Add(hook)
+
+	log.WithFields(logrus.Fields{
+		"grpc.method": "/svc/Get",
+		"env":         "field",
+		"user":        map[string]string{"id": "7"},
+		"user_id":     "42",
+	}).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "/svc/Get", received.Context["action"])
+		assert.Equal(t, "field", received.Params["env"], "fields mapped to reserved keys should stay params")
+		assert.Equal(t, map[string]interface{}{"id": "7"}, received.Context["user"], "the user field should win")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+type tenantKey struct{}
+
+func TestContextFields(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	ctxfields.Register(func(ctx context.Context) logrus.Fields {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return logrus.Fields{"tenant": tenant}
+		}
+		return nil
+	})
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	entry := log.WithField("k", "v")
+	entry.WithContext(context.WithValue(context.Background(), tenantKey{}, "acme")).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "acme", received.Params["tenant"])
+		assert.Equal(t, "v", received.Params["k"])
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+	assert.NotContains(t, entry.Data, "tenant")
+}
+
+func TestReleaseEnvironmentAndServerName(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	defer os.Setenv(buildinfo.EnvironmentVar, os.Getenv(buildinfo.EnvironmentVar))
+	os.Setenv(buildinfo.EnvironmentVar, "staging")
+
+	log := logrus.New()
+	hook := NewHook(projectID, testAPIKey, "")
+	hook.Airbrake.Client = &http.Client{Transport: &FakeRoundTripper{}}
+	hook.SetRelease("app@v1.2.0")
+	hook.SetServerName(server)
+	log.Hooks.Add(hook)
+
+	log.Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "staging", received.Context["environment"])
+		assert.Equal(t, "app@v1.2.0", received.Context["version"])
+		assert.Equal(t, server, received.Context["hostname"])
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+func TestBacktraceStartsAtCallSite(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	for _, reportCaller := range []bool{false, true} {
+		log := logrus.New()
+		log.ReportCaller = reportCaller
+		log.Hooks.Add(newTestHook())
+
+		_, _, line, _ := runtime.Caller(0)
+		log.WithField("k", "v").Error(expectedMsg)
+
+		select {
+		case received := <-noticeChan:
+			frame := received.Errors[0].Backtrace[0]
+			assert.True(t, strings.HasSuffix(frame.File, "airbrake/hook_test.go"), frame.File)
+			assert.Equal(t, line+1, frame.Line)
+		case <-time.After(time.Second):
+			t.Error("Timed out; no notice received by Airbrake API")
+		}
+	}
+}
+
 func TestLogEntryWithHTTPRequestFields(t *testing.T) {
 	if integration {
 		t.Skip()
@@ -197,8 +468,11 @@ func TestLogEntryWithHTTPRequestFields(t *testing.T) {
 		if receivedErr.Message != unintendedMsg {
 			t.Errorf("Unexpected message received: %s", receivedErr.Message)
 		}
-		if received.Context["user_id"] != "123" {
-			t.Errorf("Expected message to contain Context[\"user_id\"] == \"123\" got %q", received.Context["user_id"])
+		if user, _ := received.Context["user"].(map[string]interface{}); user["id"] != "123" {
+			t.Errorf("Expected message to contain Context[\"user\"][\"id\"] == \"123\" got %v", received.Context["user"])
+		}
+		if _, ok := received.Params["user_id"]; ok {
+			t.Error("user_id should only be sent in the user of the context")
 		}
 		if received.Context["url"] != "http://example.com" {
 			t.Errorf("Expected message to contain Context[\"url\"] == \"http://example.com\" got %q", received.Context["url"])
@@ -208,6 +482,73 @@ func TestLogEntryWithHTTPRequestFields(t *testing.T) {
 	}
 }
 
+func TestRequestFieldNotRemoved(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	req := httptest.NewRequest("GET", "http://example.com/items/1", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Pattern = "GET /items/{id}"
+	entry := log.WithField("http_request", req)
+	entry.Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "http://example.com/items/1", received.Context["url"])
+		assert.Equal(t, "GET /items/{id}", received.Context["route"])
+		assert.Equal(t, "test-agent", received.Context["userAgent"])
+		assert.Equal(t, "10.0.0.1", received.Context["userAddr"])
+		assert.NotContains(t, received.Params, "http_request")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+	// the hooks and the formatter fired after the hook get the same data
+	assert.Equal(t, req, entry.Data["http_request"], "the entry should be left untouched")
+
+	// as set by the middleware
+	log.WithField("http_request", httpreq.Value{Request: req}).Error(expectedMsg)
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "http://example.com/items/1", received.Context["url"])
+		assert.NotContains(t, received.Params, "http_request")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
+func TestSnapshotRequest(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	log := logrus.New()
+	log.Hooks.Add(newTestHook())
+
+	req := httptest.NewRequest("POST", "http://example.com/orders?id=7", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	req.RemoteAddr = "10.0.0.1:1234"
+	log.WithFields(logrus.Fields{
+		"http_request": httpreq.New(req),
+		"route":        "/orders",
+	}).Error(expectedMsg)
+
+	select {
+	case received := <-noticeChan:
+		assert.Equal(t, "http://example.com/orders?id=7", received.Context["url"])
+		assert.Equal(t, "POST", received.Context["httpMethod"])
+		assert.Equal(t, "/orders", received.Context["route"])
+		assert.Equal(t, "test-agent", received.Context["userAgent"])
+		assert.Equal(t, "10.0.0.1", received.Context["userAddr"])
+		assert.Equal(t, "test-agent", received.Env["User-Agent"])
+		assert.NotContains(t, received.Params, "http_request")
+	case <-time.After(time.Second):
+		t.Error("Timed out; no notice received by Airbrake API")
+	}
+}
+
 // Returns a new hook with the test server proxied
 func newTestHook() *Hook {
 	// Make a http.Client with the transport
@@ -249,6 +590,106 @@ func (rt *FakeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	return res, nil
 }
 
+// failingRoundTripper answers every request with a server error.
+type failingRoundTripper struct{}
+
+func (rt *failingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func TestErrorHandler(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	hook := NewHook(projectID, testAPIKey, "production")
+	hook.Airbrake.Client = &http.Client{Transport: &failingRoundTripper{}}
+	counter := &errhandler.Counter{}
+	hook.SetErrorHandler(counter)
+
+	log := logrus.New()
+	log.Hooks.Add(hook)
+	log.Error(expectedMsg)
+
+	assert.Equal(t, int64(1), counter.Count("airbrake"))
+	assert.Equal(t, uint64(1), hook.Stats().Failed)
+	assert.Equal(t, uint64(0), hook.Stats().Sent)
+	assert.Equal(t, 1, hook.Health().ConsecutiveFailures)
+}
+
+// rateLimitedRoundTripper rate limits the first request and accepts the
+// following ones.
+type rateLimitedRoundTripper struct {
+	header   http.Header
+	requests int
+}
+
+func (rt *rateLimitedRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.requests++
+	if rt.requests == 1 {
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+			Header:     rt.header,
+		}, nil
+	}
+	return &http.Response{
+		StatusCode: http.StatusCreated,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"id":"1"}`)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	if integration {
+		t.Skip()
+	}
+	for name, header := range map[string]http.Header{
+		"retry after":   {"Retry-After": []string{"0.2"}},
+		"default delay": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hook := NewHook(projectID, testAPIKey, "production")
+			rt := &rateLimitedRoundTripper{header: header}
+			hook.Airbrake.Client = &http.Client{Transport: hook.Transport(rt)}
+			counter := &errhandler.Counter{}
+			hook.SetErrorHandler(counter)
+
+			log := logrus.New()
+			log.Hooks.Add(hook)
+			for i := 0; i < 3; i++ {
+				log.Error(expectedMsg)
+			}
+
+			assert.Equal(t, 1, rt.requests, "nothing should be sent during the back-off")
+			assert.Equal(t, uint64(1), hook.Stats().Failed)
+			assert.Equal(t, uint64(2), hook.Stats().Dropped)
+			assert.Equal(t, int64(1), counter.Count("airbrake"))
+			assert.Equal(t, stats.CircuitOpen, hook.Health().Circuit)
+		})
+	}
+
+	hook := NewHook(projectID, testAPIKey, "production")
+	rt := &rateLimitedRoundTripper{header: http.Header{"Retry-After": []string{"0.2"}}}
+	hook.Airbrake.Client = &http.Client{Transport: hook.Transport(rt)}
+	hook.SetErrorHandler(&errhandler.Counter{})
+	err := hook.Fire(logrus.NewEntry(logrus.New()).WithField("k", "v"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "backing off", "the hook alone should back off, not the notifier")
+	}
+	time.Sleep(250 * time.Millisecond)
+	log := logrus.New()
+	log.Hooks.Add(hook)
+	log.Error(expectedMsg)
+
+	assert.Equal(t, 2, rt.requests, "sending should resume after the back-off")
+	assert.Equal(t, uint64(1), hook.Stats().Sent)
+	assert.Equal(t, stats.CircuitClosed, hook.Health().Circuit)
+}
+
 // Integration tests.
 func TestNewHook(t *testing.T) {
 	if !integration {
@@ -0,0 +1,123 @@
+// Package backoff tracks the periods during which a server asked a hook to
+// stop sending, e.g. with a 429 response and a Retry-After header.
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for events that are not sent because the server
+// asked the hook to back off.
+var ErrRateLimited = errors.New("rate limited by the server, event dropped")
+
+// RejectedError is returned for an event the server rejected because it rate
+// limits the hook. Unlike the events dropped with ErrRateLimited, the event
+// was sent, and may be sent again once the back-off lifts at Until.
+type RejectedError struct {
+	// Status is the HTTP status of the response, e.g. 429.
+	Status int
+	// Until is the end of the back-off the response started.
+	Until time.Time
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rate limited by the server (status %d), backing off until %s",
+		e.Status, e.Until.Format(time.RFC3339))
+}
+
+// RetryWait returns how long to wait before sending again an event that
+// failed with err: until the back-off of a RejectedError lifts. It reports
+// false for other errors and for back-offs lifting later than max from now.
+func RetryWait(err error, max time.Duration) (time.Duration, bool) {
+	var rejected *RejectedError
+	if max <= 0 || !errors.As(err, &rejected) {
+		return 0, false
+	}
+	wait := time.Until(rejected.Until)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, wait <= max
+}
+
+// DefaultRetryAfter is the back-off used when a server rate limits without
+// saying for how long.
+var DefaultRetryAfter = time.Minute
+
+// All is the category that covers every category.
+const All = ""
+
+// Backoff records until when events of each category must not be sent. The
+// zero value is ready to use and all methods are safe for concurrent use.
+type Backoff struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+	now   func() time.Time
+}
+
+// Limit stops events of category from being sent for d. It never shortens a
+// back-off already in effect.
+func (b *Backoff) Limit(category string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.until == nil {
+		b.until = make(map[string]time.Time)
+	}
+	until := b.clock().Add(d)
+	if until.After(b.until[category]) {
+		b.until[category] = until
+	}
+}
+
+// Limited reports whether events of category must not be sent now, because
+// either the category or All is backing off.
+func (b *Backoff) Limited(category string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock()
+	return now.Before(b.until[category]) || now.Before(b.until[All])
+}
+
+// Until returns the end of the back-off of category, taking All into
+// account, or the zero time when it is not backing off.
+func (b *Backoff) Until(category string) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until := b.until[category]
+	if all := b.until[All]; all.After(until) {
+		until = all
+	}
+	if !b.clock().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+func (b *Backoff) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// RetryAfter returns the back-off requested by the Retry-After header of a
+// response, given either in seconds or as an HTTP date. It returns
+// DefaultRetryAfter when the header is missing or invalid.
+func RetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return DefaultRetryAfter
+}
@@ -0,0 +1,70 @@
+package backoff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := &Backoff{now: func() time.Time { return now }}
+	assert.False(t, b.Limited("error"))
+	assert.True(t, b.Until("error").IsZero())
+
+	b.Limit("error", 10*time.Second)
+	assert.True(t, b.Limited("error"))
+	assert.False(t, b.Limited("transaction"))
+	assert.Equal(t, now.Add(10*time.Second), b.Until("error"))
+
+	b.Limit("error", time.Second)
+	assert.Equal(t, now.Add(10*time.Second), b.Until("error"), "a shorter back-off should not shorten the current one")
+
+	b.Limit(All, 20*time.Second)
+	assert.True(t, b.Limited("transaction"))
+	assert.Equal(t, now.Add(20*time.Second), b.Until("error"))
+
+	now = now.Add(20 * time.Second)
+	assert.False(t, b.Limited("error"))
+	assert.False(t, b.Limited("transaction"))
+	assert.True(t, b.Until("error").IsZero())
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := http.Header{}
+	assert.Equal(t, DefaultRetryAfter, RetryAfter(header))
+
+	header.Set("Retry-After", "30")
+	assert.Equal(t, 30*time.Second, RetryAfter(header))
+
+	header.Set("Retry-After", "0.5")
+	assert.Equal(t, 500*time.Millisecond, RetryAfter(header))
+
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, time.Hour.Seconds(), RetryAfter(header).Seconds(), 2)
+
+	header.Set("Retry-After", "soon")
+	assert.Equal(t, DefaultRetryAfter, RetryAfter(header))
+}
+
+func TestRetryWait(t *testing.T) {
+	err := fmt.Errorf("send: %w", &RejectedError{Status: http.StatusTooManyRequests, Until: time.Now().Add(time.Second)})
+	wait, ok := RetryWait(err, 2*time.Second)
+	assert.True(t, ok)
+	assert.True(t, wait > 0 && wait <= time.Second, "unexpected wait %s", wait)
+
+	_, ok = RetryWait(err, 100*time.Millisecond)
+	assert.False(t, ok, "a back-off longer than the maximum wait should not be retried")
+	_, ok = RetryWait(err, 0)
+	assert.False(t, ok, "a zero maximum wait should disable the retry")
+	_, ok = RetryWait(errors.New("connection refused"), time.Second)
+	assert.False(t, ok, "only rejected events should be retried")
+
+	wait, ok = RetryWait(&RejectedError{Until: time.Now().Add(-time.Second)}, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), wait)
+}
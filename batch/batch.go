@@ -0,0 +1,374 @@
+// Package batch implements a batching engine for hooks whose backend accepts
+// bulk payloads. Entries are encoded when they are fired and sent in batches
+// that are flushed on a maximum number of entries, a maximum size in bytes or
+// a maximum interval, whichever comes first. The asynchronous Sentry and
+// Airbrake hooks queue their events on a Batcher as well, in batches of one.
+package batch
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrQueueFull is returned by Add when the queue is full and the
+	// batcher does not block.
+	ErrQueueFull = errors.New("batch: queue is full (entry is dropped)")
+	// ErrClosed is returned by Add after Close was called.
+	ErrClosed = errors.New("batch: batcher is closed")
+	// ErrFlushTimeout is returned by Flush when the timeout elapses before
+	// all entries were sent.
+	ErrFlushTimeout = errors.New("batch: flush timed out")
+)
+
+// Item is one encoded entry of a batch.
+type Item struct {
+	// Entry is a snapshot of the entry the item was made from, see
+	// Snapshot. logrus reuses the buffer of an entry once its hooks have
+	// fired, so items never keep the entry itself.
+	Entry *logrus.Entry
+	// Payload is the encoded entry.
+	Payload []byte
+	// Value is what the payload was encoded from, for senders that deliver
+	// it rather than the payload, e.g. the event of a hook.
+	Value interface{}
+}
+
+// Snapshot returns a copy of entry that stays valid after the hooks of the
+// entry have fired: its data is copied and its buffer is left out.
+func Snapshot(entry *logrus.Entry) *logrus.Entry {
+	e := *entry
+	e.Buffer = nil
+	if entry.Data != nil {
+		e.Data = make(logrus.Fields, len(entry.Data))
+		for k, v := range entry.Data {
+			e.Data[k] = v
+		}
+	}
+	return &e
+}
+
+// Result is the outcome of sending one item.
+type Result struct {
+	Item Item
+	Err  error
+}
+
+// Encoder converts an entry to the payload sent for it.
+type Encoder func(entry *logrus.Entry) ([]byte, error)
+
+// Sender delivers a batch. It returns nil when every item was delivered, or
+// one error per item, nil for the items that were delivered.
+type Sender func(items []Item) []error
+
+// FailAll returns a result slice failing all n items of a batch with err.
+func FailAll(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Config configures a Batcher.
+type Config struct {
+	// MaxEntries is the maximum number of items in a batch. Defaults to 100.
+	MaxEntries int
+	// MaxBytes is the maximum total payload size of a batch. A single item
+	// larger than this is sent on its own. Zero means no limit.
+	MaxBytes int
+	// MaxInterval is the maximum time an item waits for its batch to fill.
+	// Defaults to one second.
+	MaxInterval time.Duration
+	// Workers is the number of batches sent concurrently. Defaults to 1.
+	Workers int
+	// QueueSize is the number of items buffered before backpressure is
+	// applied. Defaults to 1000.
+	QueueSize int
+	// Block makes Add wait for room in a full queue instead of dropping the
+	// entry.
+	Block bool
+	// OnResult, if set, is called with the outcome of every item, e.g. to
+	// spool failed items.
+	OnResult func(Result)
+}
+
+// Batcher collects items and sends them in batches.
+type Batcher struct {
+	// Name identifies the batcher to the error handler.
+	Name string
+
+	cfg          Config
+	encode       Encoder
+	send         Sender
+	errorHandler errhandler.ErrorHandler
+	stats        stats.Recorder
+
+	queue   chan Item
+	flushCh chan struct{}
+	work    chan []Item
+	workers sync.WaitGroup
+
+	closeMu sync.RWMutex
+	closed  bool
+
+	mu      sync.Mutex
+	pending int
+	waiters []chan struct{}
+}
+
+// New starts a Batcher that encodes entries with encode and delivers them
+// with send. encode may be nil when items are only queued with AddItem.
+func New(name string, cfg Config, encode Encoder, send Sender) *Batcher {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 100
+	}
+	if cfg.MaxInterval <= 0 {
+		cfg.MaxInterval = time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	b := &Batcher{
+		Name:         name,
+		cfg:          cfg,
+		encode:       encode,
+		send:         send,
+		errorHandler: errhandler.Stderr,
+		queue:        make(chan Item, cfg.QueueSize),
+		flushCh:      make(chan struct{}, 1),
+		work:         make(chan []Item),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		b.workers.Add(1)
+		go b.worker()
+	}
+	go b.collect()
+	return b
+}
+
+// Add encodes entry and queues it for the next batch.
+func (b *Batcher) Add(entry *logrus.Entry) error {
+	payload, err := b.encode(entry)
+	if err != nil {
+		return err
+	}
+	return b.AddItem(Item{Entry: Snapshot(entry), Payload: payload})
+}
+
+// AddItem queues an item encoded by the caller for the next batch.
+func (b *Batcher) AddItem(item Item) error {
+	b.closeMu.RLock()
+	defer b.closeMu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.addPending(1)
+	b.stats.AddQueueDepth(1)
+	if b.cfg.Block {
+		b.queue <- item
+	} else {
+		select {
+		case b.queue <- item:
+		default:
+			b.addPending(-1)
+			b.stats.AddQueueDepth(-1)
+			b.stats.Dropped()
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// Flush sends the current batch and waits until every queued item was sent
+// or timeout elapses.
+func (b *Batcher) Flush(timeout time.Duration) error {
+	b.mu.Lock()
+	if b.pending == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	b.waiters = append(b.waiters, done)
+	b.mu.Unlock()
+
+	select {
+	case b.flushCh <- struct{}{}:
+	default: // a flush is already requested
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return ErrFlushTimeout
+	}
+}
+
+// Close stops accepting entries, sends the queued ones and waits for the
+// workers to finish.
+func (b *Batcher) Close() error {
+	b.closeMu.Lock()
+	if b.closed {
+		b.closeMu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.queue)
+	b.closeMu.Unlock()
+
+	b.workers.Wait()
+	return nil
+}
+
+// SetErrorHandler sets the handler failed items are reported to.
+func (b *Batcher) SetErrorHandler(handler errhandler.ErrorHandler) {
+	b.errorHandler = handler
+}
+
+// Stats returns a snapshot of the delivery statistics of the batcher.
+func (b *Batcher) Stats() stats.Snapshot {
+	return b.stats.Snapshot(b.Name)
+}
+
+// Health returns the current delivery health of the batcher.
+func (b *Batcher) Health() stats.Health {
+	h := b.stats.Health(b.Name)
+	h.QueueSaturation = float64(len(b.queue)) / float64(cap(b.queue))
+	return h
+}
+
+// collect groups queued items into batches and hands them to the workers.
+func (b *Batcher) collect() {
+	defer close(b.work)
+
+	var batch []Item
+	size := 0
+	timer := time.NewTimer(b.cfg.MaxInterval)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			b.work <- batch
+			batch, size = nil, 0
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(b.cfg.MaxInterval)
+	}
+
+	for {
+		select {
+		case item, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if b.cfg.MaxBytes > 0 && len(batch) > 0 && size+len(item.Payload) > b.cfg.MaxBytes {
+				flush()
+			}
+			batch = append(batch, item)
+			size += len(item.Payload)
+			if len(batch) >= b.cfg.MaxEntries || (b.cfg.MaxBytes > 0 && size >= b.cfg.MaxBytes) {
+				flush()
+			}
+		case <-b.flushCh:
+			// drain what is already queued so Flush covers it
+			for n := len(b.queue); n > 0; n-- {
+				item, ok := <-b.queue
+				if !ok {
+					break
+				}
+				batch = append(batch, item)
+				size += len(item.Payload)
+				if len(batch) >= b.cfg.MaxEntries || (b.cfg.MaxBytes > 0 && size >= b.cfg.MaxBytes) {
+					flush()
+				}
+			}
+			flush()
+		case <-timer.C:
+			if len(batch) > 0 {
+				b.work <- batch
+				batch, size = nil, 0
+			}
+			timer.Reset(b.cfg.MaxInterval)
+		}
+	}
+}
+
+func (b *Batcher) worker() {
+	defer b.workers.Done()
+	for items := range b.work {
+		// items count as queued until a worker takes their batch
+		b.stats.AddQueueDepth(-int64(len(items)))
+		b.stats.AddInFlight(1)
+		errs := b.send(items)
+		b.stats.AddInFlight(-1)
+
+		for i, item := range items {
+			var err error
+			if i < len(errs) {
+				err = errs[i]
+			}
+			if err != nil {
+				b.stats.Failed(err)
+				if b.errorHandler != nil {
+					b.errorHandler.HandleError(b.Name, item, err)
+				}
+			} else {
+				b.stats.Sent()
+			}
+			if b.cfg.OnResult != nil {
+				b.cfg.OnResult(Result{Item: item, Err: err})
+			}
+		}
+		b.addPending(-len(items))
+	}
+}
+
+func (b *Batcher) addPending(delta int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending += delta
+	if b.pending == 0 {
+		for _, w := range b.waiters {
+			close(w)
+		}
+		b.waiters = nil
+	}
+}
+
+// Hook is a logrus hook that adds entries to a Batcher.
+type Hook struct {
+	*Batcher
+	levels []logrus.Level
+}
+
+// NewHook returns a hook adding the entries of the given levels to b.
+func NewHook(b *Batcher, levels []logrus.Level) *Hook {
+	return &Hook{Batcher: b, levels: levels}
+}
+
+// Levels returns the levels the hook fires for.
+func (hook *Hook) Levels() []logrus.Level {
+	return hook.levels
+}
+
+// Fire adds the entry to the batcher.
+func (hook *Hook) Fire(entry *logrus.Entry) error {
+	defer func(start time.Time) { hook.stats.ObserveFire(time.Since(start)) }(time.Now())
+	return hook.Add(entry)
+}
@@ -0,0 +1,169 @@
+package batch
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeMessage(entry *logrus.Entry) ([]byte, error) {
+	return []byte(entry.Message), nil
+}
+
+// recordingSender records the sizes of the batches it is called with.
+type recordingSender struct {
+	mu      sync.Mutex
+	batches [][]string
+	fail    map[string]error
+}
+
+func (s *recordingSender) send(items []Item) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []string
+	var errs []error
+	for _, item := range items {
+		msgs = append(msgs, string(item.Payload))
+		errs = append(errs, s.fail[string(item.Payload)])
+	}
+	s.batches = append(s.batches, msgs)
+	return errs
+}
+
+func (s *recordingSender) all() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...)
+}
+
+func newTestLogger(hook logrus.Hook) *logrus.Logger {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	l.AddHook(hook)
+	return l
+}
+
+func TestFlushOnMaxEntries(t *testing.T) {
+	s := &recordingSender{}
+	b := New("bulk", Config{MaxEntries: 2, MaxInterval: time.Hour}, encodeMessage, s.send)
+	log := newTestLogger(NewHook(b, logrus.AllLevels))
+
+	log.Error("a")
+	log.Error("b")
+	log.Error("c")
+	assert.NoError(t, b.Flush(time.Second))
+
+	assert.Equal(t, [][]string{{"a", "b"}, {"c"}}, s.all())
+	assert.Equal(t, uint64(3), b.Stats().Sent)
+	assert.NoError(t, b.Close())
+}
+
+func TestFlushOnMaxBytes(t *testing.T) {
+	s := &recordingSender{}
+	b := New("bulk", Config{MaxBytes: 5, MaxInterval: time.Hour}, encodeMessage, s.send)
+
+	for _, msg := range []string{"aa", "bb", "cc", "dddddd"} {
+		assert.NoError(t, b.Add(&logrus.Entry{Message: msg}))
+	}
+	assert.NoError(t, b.Close())
+
+	assert.Equal(t, [][]string{{"aa", "bb"}, {"cc"}, {"dddddd"}}, s.all())
+}
+
+func TestFlushOnMaxInterval(t *testing.T) {
+	s := &recordingSender{}
+	b := New("bulk", Config{MaxInterval: 10 * time.Millisecond}, encodeMessage, s.send)
+	defer b.Close()
+
+	assert.NoError(t, b.Add(&logrus.Entry{Message: "a"}))
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, [][]string{{"a"}}, s.all())
+}
+
+func TestPartialFailure(t *testing.T) {
+	boom := errors.New("boom")
+	s := &recordingSender{fail: map[string]error{"b": boom}}
+	var results []Result
+	counter := &errhandler.Counter{}
+	b := New("bulk", Config{
+		MaxInterval: time.Hour,
+		OnResult:    func(r Result) { results = append(results, r) },
+	}, encodeMessage, s.send)
+	b.SetErrorHandler(counter)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		assert.NoError(t, b.Add(&logrus.Entry{Message: msg}))
+	}
+	assert.NoError(t, b.Close())
+
+	assert.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, boom, results[1].Err)
+	assert.Equal(t, "b", string(results[1].Item.Payload))
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, int64(1), counter.Count("bulk"))
+	assert.Equal(t, uint64(2), b.Stats().Sent)
+	assert.Equal(t, uint64(1), b.Stats().Failed)
+}
+
+func TestBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	send := func(items []Item) []error {
+		<-release
+		return nil
+	}
+	b := New("bulk", Config{MaxEntries: 1, QueueSize: 1}, encodeMessage, send)
+
+	// one item is held by the worker, one by the collector and one queued
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = b.Add(&logrus.Entry{Message: "a"})
+	}
+	assert.Equal(t, ErrQueueFull, err)
+	assert.NotZero(t, b.Stats().Dropped)
+
+	close(release)
+	assert.NoError(t, b.Close())
+	assert.Equal(t, ErrClosed, b.Add(&logrus.Entry{Message: "a"}))
+}
+
+func TestFlushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	b := New("bulk", Config{}, encodeMessage, func(items []Item) []error {
+		<-release
+		return nil
+	})
+	assert.NoError(t, b.Add(&logrus.Entry{Message: "a"}))
+	assert.Equal(t, ErrFlushTimeout, b.Flush(10*time.Millisecond))
+
+	close(release)
+	assert.NoError(t, b.Flush(time.Second))
+	assert.NoError(t, b.Close())
+}
+
+func TestItemSnapshot(t *testing.T) {
+	var items []Item
+	b := New("bulk", Config{MaxInterval: time.Hour, OnResult: func(r Result) { items = append(items, r.Item) }}, encodeMessage, func(items []Item) []error { return nil })
+
+	entry := logrus.NewEntry(logrus.New()).WithField("k", "v")
+	entry.Message = "a"
+	assert.NoError(t, b.Add(entry))
+	entry.Data["k"] = "changed"
+	assert.NoError(t, b.AddItem(Item{Value: 42}))
+	assert.NoError(t, b.Close())
+
+	if assert.Len(t, items, 2) {
+		assert.NotSame(t, entry, items[0].Entry, "items should not keep the entry")
+		assert.Equal(t, "v", items[0].Entry.Data["k"], "items should keep the fields the entry was fired with")
+		assert.Equal(t, "a", items[0].Entry.Message)
+		assert.Equal(t, 42, items[1].Value)
+	}
+	assert.Equal(t, int64(0), b.Stats().QueueDepth)
+}
@@ -0,0 +1,104 @@
+// Package buildinfo derives the release, revision, environment and server
+// name of the running program, which the hooks use as defaults.
+package buildinfo
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// EnvironmentVar is the environment variable Environment reads.
+var EnvironmentVar = "ENVIRONMENT"
+
+// shortRevision is the length revisions are cut to in releases.
+const shortRevision = 12
+
+// Release returns the release of the program: the path of its main module,
+// "@" and its version, followed by "+" and the short VCS revision it was
+// built from, e.g. "github.com/acme/app@v1.2.0+0123456789ab". Programs built
+// without module version use the revision as version. Release returns an
+// empty string when neither is known.
+func Release() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return release(info)
+}
+
+// Revision returns the VCS revision the program was built from, suffixed
+// with "-dirty" when the working tree had local modifications, or an empty
+// string when it is not known.
+func Revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return revision(info)
+}
+
+// MainModule returns the path of the main module of the program, or an empty
+// string when it is not known.
+func MainModule() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Path == "command-line-arguments" {
+		return ""
+	}
+	return info.Main.Path
+}
+
+// Environment returns the value of the EnvironmentVar environment variable.
+func Environment() string {
+	return os.Getenv(EnvironmentVar)
+}
+
+// ServerName returns the host name, or an empty string when it is not known.
+func ServerName() string {
+	name, _ := os.Hostname()
+	return name
+}
+
+func release(info *debug.BuildInfo) string {
+	version := info.Main.Version
+	if version == "(devel)" {
+		version = ""
+	}
+	rev, dirty := vcsRevision(info)
+	if len(rev) > shortRevision {
+		rev = rev[:shortRevision]
+	}
+	if rev != "" && dirty {
+		rev += "-dirty"
+	}
+	switch {
+	case info.Main.Path == "" || version == "" && rev == "":
+		return ""
+	case version == "":
+		return info.Main.Path + "@" + rev
+	case rev == "":
+		return info.Main.Path + "@" + version
+	}
+	return info.Main.Path + "@" + version + "+" + rev
+}
+
+func revision(info *debug.BuildInfo) string {
+	rev, dirty := vcsRevision(info)
+	if rev != "" && dirty {
+		rev += "-dirty"
+	}
+	return rev
+}
+
+// vcsRevision returns the VCS revision recorded in info and whether the
+// working tree was modified.
+func vcsRevision(info *debug.BuildInfo) (rev string, dirty bool) {
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	return rev, dirty
+}
@@ -0,0 +1,59 @@
+package buildinfo
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelease(t *testing.T) {
+	const rev = "0123456789abcdef0123456789abcdef01234567"
+	vcs := func(modified string) []debug.BuildSetting {
+		return []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: rev},
+			{Key: "vcs.modified", Value: modified},
+		}
+	}
+	tests := []struct {
+		version  string
+		settings []debug.BuildSetting
+		release  string
+		revision string
+	}{
+		{"v1.2.0", vcs("false"), "example.com/app@v1.2.0+0123456789ab", rev},
+		{"v1.2.0", vcs("true"), "example.com/app@v1.2.0+0123456789ab-dirty", rev + "-dirty"},
+		{"(devel)", vcs("false"), "example.com/app@0123456789ab", rev},
+		{"v1.2.0", nil, "example.com/app@v1.2.0", ""},
+		{"(devel)", nil, "", ""},
+	}
+	for _, tt := range tests {
+		info := &debug.BuildInfo{
+			Main:     debug.Module{Path: "example.com/app", Version: tt.version},
+			Settings: tt.settings,
+		}
+		assert.Equal(t, tt.release, release(info))
+		assert.Equal(t, tt.revision, revision(info))
+	}
+
+	assert.Empty(t, release(&debug.BuildInfo{Settings: vcs("false")}), "no release without a main module")
+}
+
+func TestEnvironment(t *testing.T) {
+	defer os.Setenv(EnvironmentVar, os.Getenv(EnvironmentVar))
+	os.Setenv(EnvironmentVar, "staging")
+	assert.Equal(t, "staging", Environment())
+}
+
+func TestMainModule(t *testing.T) {
+	module := MainModule()
+	if module == "" {
+		t.Skip("built without module information")
+	}
+	pc, _, _, _ := runtime.Caller(0)
+	assert.True(t, strings.HasPrefix(runtime.FuncForPC(pc).Name(), module+"/"), module)
+}
@@ -0,0 +1,122 @@
+// Package callers finds the call site of a log entry on the stack, so that
+// hooks report where an entry was logged rather than where it was fired.
+package callers
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxDepth bounds the number of frames Stack looks at.
+const maxDepth = 100
+
+var (
+	mu sync.RWMutex
+	// skipped are the packages whose frames are never the call site.
+	skipped []string
+	// logrusPath is the import path of logrus.
+	logrusPath string
+)
+
+func init() {
+	logrusPath = packagePath(funcName(logrus.New))
+	// The runtime is skipped so that entries logged while panicking, e.g.
+	// from a deferred recover, start at the frame that panicked.
+	skipped = []string{logrusPath, strings.TrimSuffix(packagePath(funcName(Stack)), "/callers"), "runtime"}
+}
+
+// funcName returns the fully qualified name of fn. The import paths derived
+// from it also hold when the packages are vendored.
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// SkipPackages registers packages whose frames are skipped when looking for
+// the call site of an entry, such as logging wrappers. A package path also
+// covers its subpackages. Logrus, this module and the runtime are always
+// skipped.
+func SkipPackages(paths ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	skipped = append(skipped, paths...)
+}
+
+// Stack returns the frames of the current goroutine from the call site of
+// entry outwards, and the number of frames between the caller of Stack and
+// the call site.
+//
+// The call site is entry.Caller when logrus reports callers. Otherwise it is
+// the first frame below the logrus frames that is outside logrus, this module
+// (except its tests), the runtime and the packages registered with
+// SkipPackages; so is a reported caller inside those packages, which some
+// logrus versions report for Entry methods. When the call site is not on the stack, e.g. because
+// the entry is fired from another goroutine, Stack returns entry.Caller
+// alone, or no frames, and -1.
+func Stack(entry *logrus.Entry) ([]runtime.Frame, int) {
+	caller := entry.Caller
+	if caller != nil && isSkipped(*caller) {
+		caller = nil
+	}
+
+	pcs := make([]uintptr, maxDepth)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []runtime.Frame
+	site := -1
+	inLogrus := false
+	for i := 0; ; i++ {
+		frame, more := frames.Next()
+		if site < 0 {
+			switch {
+			case caller != nil:
+				if frame.Function == caller.Function && frame.Line == caller.Line {
+					site = i
+				}
+			case packagePath(frame.Function) == logrusPath:
+				inLogrus = true
+			case inLogrus && !isSkipped(frame):
+				site = i
+			}
+		}
+		if site >= 0 && frame.Function != "runtime.goexit" {
+			stack = append(stack, frame)
+		}
+		if !more {
+			break
+		}
+	}
+	if site < 0 && caller != nil {
+		return []runtime.Frame{*caller}, -1
+	}
+	return stack, site
+}
+
+func isSkipped(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	pkg := packagePath(frame.Function)
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, path := range skipped {
+		if pkg == path || strings.HasPrefix(pkg, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// packagePath returns the import path of the package of a function, given
+// its fully qualified name, e.g. "github.com/sirupsen/logrus.(*Entry).Log".
+func packagePath(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot >= 0 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
@@ -0,0 +1,83 @@
+package callers
+
+import (
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type stackHook struct {
+	frames []runtime.Frame
+	site   int
+}
+
+func (h *stackHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *stackHook) Fire(entry *logrus.Entry) error {
+	h.frames, h.site = Stack(entry)
+	return nil
+}
+
+func newLogger(reportCaller bool) (*logrus.Logger, *stackHook) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.ReportCaller = reportCaller
+	hook := &stackHook{}
+	logger.AddHook(hook)
+	return logger, hook
+}
+
+func TestStack(t *testing.T) {
+	for _, reportCaller := range []bool{false, true} {
+		logger, hook := newLogger(reportCaller)
+
+		_, _, line, _ := runtime.Caller(0)
+		logger.WithField("k", "v").Error("boom")
+
+		if assert.NotEmpty(t, hook.frames) {
+			site := hook.frames[0]
+			assert.Equal(t, "github.com/CIP-NL/logrus-hooks/callers.TestStack", site.Function)
+			assert.Equal(t, line+1, site.Line)
+		}
+		assert.True(t, hook.site > 1, "the frames of logrus should be counted")
+		for _, f := range hook.frames {
+			assert.NotEqual(t, "runtime.goexit", f.Function)
+		}
+	}
+}
+
+func TestStackCallerNotOnStack(t *testing.T) {
+	caller := &runtime.Frame{Function: "example.com/app.handler", File: "app.go", Line: 12}
+	frames, site := Stack(&logrus.Entry{Caller: caller})
+	assert.Equal(t, []runtime.Frame{*caller}, frames)
+	assert.Equal(t, -1, site)
+}
+
+func TestIsSkipped(t *testing.T) {
+	SkipPackages("example.com/app/logging")
+	defer func() { skipped = skipped[:len(skipped)-1] }()
+
+	tests := []struct {
+		function string
+		file     string
+		skipped  bool
+	}{
+		{"github.com/sirupsen/logrus.(*Entry).Log", "entry.go", true},
+		{"github.com/CIP-NL/logrus-hooks/sentry.(*Hook).Fire", "hook.go", true},
+		{"github.com/CIP-NL/logrus-hooks.(*DedupHook).Fire", "dedup.go", true},
+		{"github.com/CIP-NL/logrus-hooks/sentry.TestFire", "hook_test.go", false},
+		{"example.com/app/logging.Errorf", "logging.go", true},
+		{"example.com/app/logging/v2.Errorf", "logging.go", true},
+		{"example.com/app/loggingx.Errorf", "logging.go", false},
+		{"example.com/app.handler.func1", "app.go", false},
+		{"main.main", "main.go", false},
+		{"runtime.gopanic", "panic.go", true},
+		{"runtime/debug.Stack", "stack.go", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.skipped, isSkipped(runtime.Frame{Function: tt.function, File: tt.file}), tt.function)
+	}
+}
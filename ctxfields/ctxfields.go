@@ -0,0 +1,77 @@
+// Package ctxfields lets the hooks add request scoped values, such as the
+// user or the tenant, to the events of the entries logged with a context,
+// without adding them to the fields of the entries and so to the local
+// output.
+package ctxfields
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Extractor returns the fields to add to the events of the entries logged
+// with ctx. It must be safe for concurrent use.
+type Extractor func(ctx context.Context) logrus.Fields
+
+var (
+	mu         sync.RWMutex
+	extractors []Extractor
+)
+
+// Register adds an extractor run by the hooks for every entry with a
+// context. Extractors run in the order they are registered; a field returned
+// by a later extractor replaces the one returned by an earlier one.
+func Register(e Extractor) {
+	mu.Lock()
+	defer mu.Unlock()
+	extractors = append(extractors, e)
+}
+
+// Extract runs the registered extractors on ctx.
+func Extract(ctx context.Context) logrus.Fields {
+	mu.RLock()
+	defer mu.RUnlock()
+	var fields logrus.Fields
+	for _, e := range extractors {
+		for k, v := range e(ctx) {
+			if fields == nil {
+				fields = make(logrus.Fields)
+			}
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// extractedKey marks a context whose fields are already in the data of the
+// entries logged with it.
+type extractedKey struct{}
+
+// Extracted returns ctx marked as already extracted: Merge returns the data
+// of the entries logged with it as they are. Wrappers that merge the fields
+// before the hooks see them, such as the redacting one, mark the context of
+// the entries they pass on, so that the hooks do not add back the fields
+// they removed.
+func Extracted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, extractedKey{}, true)
+}
+
+// Merge returns the fields of entry together with those extracted from
+// entry.Context, the fields of entry winning. It returns entry.Data itself
+// when nothing is extracted or the context is marked Extracted, and a new
+// map otherwise.
+func Merge(entry *logrus.Entry) logrus.Fields {
+	if entry.Context == nil || entry.Context.Value(extractedKey{}) != nil {
+		return entry.Data
+	}
+	extracted := Extract(entry.Context)
+	if len(extracted) == 0 {
+		return entry.Data
+	}
+	for k, v := range entry.Data {
+		extracted[k] = v
+	}
+	return extracted
+}
@@ -0,0 +1,53 @@
+package ctxfields
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantKey struct{}
+
+func TestMerge(t *testing.T) {
+	defer func() { extractors = nil }()
+	Register(func(ctx context.Context) logrus.Fields {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return logrus.Fields{"tenant": tenant, "source": "first"}
+		}
+		return nil
+	})
+	Register(func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"source": "second"}
+	})
+
+	entry := logrus.NewEntry(logrus.New()).WithField("k", "v")
+	assert.Equal(t, logrus.Fields{"k": "v"}, Merge(entry), "entries without a context should keep their fields")
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+	data := Merge(entry.WithContext(ctx))
+	assert.Equal(t, logrus.Fields{"k": "v", "tenant": "acme", "source": "second"}, data)
+	assert.Equal(t, logrus.Fields{"k": "v"}, entry.Data, "the fields of the entry should be left untouched")
+
+	data = Merge(entry.WithField("tenant", "explicit").WithContext(ctx))
+	assert.Equal(t, "explicit", data["tenant"], "the fields of the entry should win")
+}
+
+func TestMergeWithoutExtractors(t *testing.T) {
+	entry := logrus.NewEntry(logrus.New()).WithField("k", "v").WithContext(context.Background())
+	data := Merge(entry)
+	data["x"] = 1
+	assert.Equal(t, 1, entry.Data["x"], "the fields of the entry should be returned as is")
+}
+
+func TestMergeExtracted(t *testing.T) {
+	defer func() { extractors = nil }()
+	Register(func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"tenant": "acme"}
+	})
+
+	entry := logrus.NewEntry(logrus.New()).WithField("k", "v")
+	data := Merge(entry.WithContext(Extracted(context.Background())))
+	assert.Equal(t, logrus.Fields{"k": "v"}, data, "the fields of an extracted context should not be added again")
+}
@@ -0,0 +1,223 @@
+package logrus_hooks
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+)
+
+// Fields added to the summary entry emitted by a DedupHook.
+const (
+	FieldRepeatCount = "repeat_count"
+	FieldFirstSeen   = "first_seen"
+	FieldLastSeen    = "last_seen"
+)
+
+// DedupHook wraps a hook and suppresses repeated entries inside a time window.
+// The first entry of a window is forwarded as is. When the window closes and
+// repeats were suppressed, one summary entry carrying repeat_count, first_seen
+// and last_seen is forwarded in their place.
+type DedupHook struct {
+	// Hook is the wrapped hook.
+	Hook logrus.Hook
+	// Window is the period during which repeats of an entry are suppressed.
+	Window time.Duration
+	// Fingerprint returns the key on which entries are considered equal.
+	// Defaults to DefaultFingerprint.
+	Fingerprint func(entry *logrus.Entry) string
+	// Name identifies the hook to the error handler.
+	Name string
+
+	errorHandler errhandler.ErrorHandler
+	stats        stats.Recorder
+
+	mu   sync.Mutex
+	seen map[string]*dedupState
+}
+
+type dedupState struct {
+	last      entrySnapshot
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	timer     *time.Timer
+}
+
+// NewDedupHook returns a DedupHook wrapping hook with the given window.
+func NewDedupHook(hook logrus.Hook, window time.Duration) *DedupHook {
+	return &DedupHook{
+		Hook:         hook,
+		Window:       window,
+		Fingerprint:  DefaultFingerprint,
+		Name:         "dedup",
+		errorHandler: errhandler.Stderr,
+		seen:         make(map[string]*dedupState),
+	}
+}
+
+// DefaultFingerprint keys an entry on its level, message, error type and
+// caller. When the entry carries a sentry fingerprint ([]string under the
+// "fingerprint" field) that is used instead.
+func DefaultFingerprint(entry *logrus.Entry) string {
+	if fp, ok := entry.Data["fingerprint"].([]string); ok && len(fp) > 0 {
+		return strings.Join(fp, "\x00")
+	}
+	var errType, caller string
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		errType = fmt.Sprintf("%T", err)
+	}
+	if entry.Caller != nil {
+		caller = fmt.Sprintf("%s:%d", entry.Caller.File, entry.Caller.Line)
+	}
+	return strings.Join([]string{entry.Level.String(), entry.Message, errType, caller}, "\x00")
+}
+
+// Levels returns the levels of the wrapped hook.
+func (hook *DedupHook) Levels() []logrus.Level {
+	return hook.Hook.Levels()
+}
+
+// Fire forwards the entry unless an equal entry was already forwarded in the
+// current window.
+func (hook *DedupHook) Fire(entry *logrus.Entry) error {
+	fingerprint := hook.Fingerprint
+	if fingerprint == nil {
+		fingerprint = DefaultFingerprint
+	}
+	if passThrough(hook.Hook, entry) {
+		return hook.Hook.Fire(entry)
+	}
+	key := fingerprint(entry)
+
+	hook.mu.Lock()
+	if st, ok := hook.seen[key]; ok {
+		st.count++
+		st.last = snapshot(entry)
+		st.lastSeen = entry.Time
+		hook.mu.Unlock()
+		hook.stats.Dropped()
+		return nil
+	}
+	st := &dedupState{firstSeen: entry.Time, lastSeen: entry.Time}
+	st.timer = time.AfterFunc(hook.Window, func() { hook.expire(key) })
+	hook.seen[key] = st
+	hook.mu.Unlock()
+
+	return hook.Hook.Fire(entry)
+}
+
+// Flush closes all open windows, emitting their summaries immediately.
+func (hook *DedupHook) Flush() {
+	hook.mu.Lock()
+	keys := make([]string, 0, len(hook.seen))
+	for k, st := range hook.seen {
+		st.timer.Stop()
+		keys = append(keys, k)
+	}
+	hook.mu.Unlock()
+
+	for _, k := range keys {
+		hook.expire(k)
+	}
+}
+
+func (hook *DedupHook) expire(key string) {
+	hook.mu.Lock()
+	st, ok := hook.seen[key]
+	delete(hook.seen, key)
+	hook.mu.Unlock()
+
+	if !ok || st.count == 0 {
+		return
+	}
+	summary := st.last.entry(logrus.Fields{
+		FieldRepeatCount: st.count,
+		FieldFirstSeen:   st.firstSeen,
+		FieldLastSeen:    st.lastSeen,
+	})
+	if err := hook.Hook.Fire(summary); err != nil && hook.errorHandler != nil {
+		hook.errorHandler.HandleError(hook.Name, summary, err)
+	}
+}
+
+// SetErrorHandler sets the handler failures to fire a summary are reported
+// to, and passes it on to the wrapped hook.
+func (hook *DedupHook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	hook.errorHandler = handler
+	setErrorHandler(hook.Hook, handler)
+}
+
+// Stats returns the statistics of the wrapped hook, with the suppressed
+// repeats counted as dropped.
+func (hook *DedupHook) Stats() stats.Snapshot {
+	return addDropped(hook.Hook, &hook.stats)
+}
+
+// Captures reports whether the wrapped hook sends entries of level as events.
+func (hook *DedupHook) Captures(level logrus.Level) bool {
+	return captures(hook.Hook, level)
+}
+
+// Health returns the health of the wrapped hook.
+func (hook *DedupHook) Health() stats.Health {
+	h, _ := healthOf(hook.Hook)
+	return h
+}
+
+// entrySnapshot holds what a wrapper needs to rebuild an entry after Fire
+// returned. The entry itself is reused by logrus, and its buffer written
+// to, once the hooks return, so it must not be kept.
+type entrySnapshot struct {
+	logger  *logrus.Logger
+	data    logrus.Fields
+	time    time.Time
+	level   logrus.Level
+	message string
+	caller  *runtime.Frame
+	context context.Context
+}
+
+// snapshot copies the message, level, time, caller, context and fields of
+// entry.
+func snapshot(entry *logrus.Entry) entrySnapshot {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	return entrySnapshot{
+		logger:  entry.Logger,
+		data:    data,
+		time:    entry.Time,
+		level:   entry.Level,
+		message: entry.Message,
+		caller:  entry.Caller,
+		context: entry.Context,
+	}
+}
+
+// entry returns a new entry rebuilt from the snapshot with fields added.
+func (s entrySnapshot) entry(fields logrus.Fields) *logrus.Entry {
+	data := make(logrus.Fields, len(s.data)+len(fields))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+	return &logrus.Entry{
+		Logger:  s.logger,
+		Data:    data,
+		Time:    s.time,
+		Level:   s.level,
+		Message: s.message,
+		Caller:  s.caller,
+		Context: s.context,
+	}
+}
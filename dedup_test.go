@@ -0,0 +1,112 @@
+package logrus_hooks
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingHook stores every entry it is fired with.
+type recordingHook struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (h *recordingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *recordingHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func (h *recordingHook) all() []*logrus.Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*logrus.Entry(nil), h.entries...)
+}
+
+func newTestLogger(hook logrus.Hook) *logrus.Logger {
+	l := logrus.New()
+	l.Out = ioutil.Discard
+	l.AddHook(hook)
+	return l
+}
+
+func TestDedupHookSuppressesRepeats(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewDedupHook(rec, time.Hour)
+	log := newTestLogger(hook)
+
+	for i := 0; i < 5; i++ {
+		log.Error("boom")
+	}
+	log.Error("other")
+
+	assert.Len(t, rec.all(), 2)
+
+	hook.Flush()
+	entries := rec.all()
+	assert.Len(t, entries, 3)
+	summary := entries[2]
+	assert.Equal(t, "boom", summary.Message)
+	assert.Equal(t, 4, summary.Data[FieldRepeatCount])
+	assert.NotNil(t, summary.Data[FieldFirstSeen])
+	assert.NotNil(t, summary.Data[FieldLastSeen])
+}
+
+func TestDedupHookWindowCloses(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(NewDedupHook(rec, 20*time.Millisecond))
+
+	log.Error("boom")
+	log.Error("boom")
+
+	time.Sleep(100 * time.Millisecond)
+	entries := rec.all()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 1, entries[1].Data[FieldRepeatCount])
+
+	log.Error("boom")
+	assert.Len(t, rec.all(), 3)
+}
+
+func TestDedupHookFingerprintField(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewDedupHook(rec, time.Hour)
+	log := newTestLogger(hook)
+
+	log.WithField("fingerprint", []string{"db"}).Error("timeout on host a")
+	log.WithField("fingerprint", []string{"db"}).Error("timeout on host b")
+
+	assert.Len(t, rec.all(), 1)
+}
+
+func TestDedupHookSnapshotsEntry(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewDedupHook(rec, time.Hour)
+	log := newTestLogger(hook)
+
+	log.Error("boom")
+	repeat := log.WithField("k", "v")
+	repeat.Error("boom")
+	// logrus and the caller are free to reuse the entry once it was logged
+	repeat.Data["k"] = "changed"
+
+	hook.Flush()
+	entries := rec.all()
+	if assert.Len(t, entries, 2) {
+		summary := entries[1]
+		assert.Equal(t, "v", summary.Data["k"])
+		assert.Equal(t, logrus.ErrorLevel, summary.Level)
+		assert.Nil(t, summary.Buffer)
+		assert.Equal(t, summary.Data[FieldLastSeen], summary.Time)
+	}
+}
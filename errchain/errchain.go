@@ -0,0 +1,72 @@
+// Package errchain flattens chains of wrapped errors, following Go 1.13
+// Unwrap() error, Unwrap() []error (e.g. errors.Join) and pkg/errors
+// Cause() error.
+package errchain
+
+import "fmt"
+
+// maxLinks bounds the length of a chain, which also guards against cycles.
+const maxLinks = 32
+
+// Link is one error of a chain.
+type Link struct {
+	// Err is the error.
+	Err error
+	// Layers are Err and the wrappers merged into it, outermost first. A
+	// wrapper that adds nothing to the message of the single error it wraps,
+	// such as the stack of pkg/errors.WithStack, is merged into that error
+	// instead of becoming a link of its own. Check the layers in order when
+	// looking for a stack trace.
+	Layers []error
+}
+
+// Chain returns err and the errors it wraps, outermost first. The errors of
+// a join follow each other in order, each followed by the errors it wraps.
+func Chain(err error) []Link {
+	var links []Link
+	walk(err, nil, &links)
+	return links
+}
+
+func walk(err error, layers []error, links *[]Link) {
+	if err == nil || len(*links) >= maxLinks {
+		return
+	}
+	layers = append(layers, err)
+	next := Unwrap(err)
+	if len(next) == 1 && next[0] != nil && next[0].Error() == err.Error() {
+		walk(next[0], layers, links)
+		return
+	}
+	*links = append(*links, Link{Err: err, Layers: layers})
+	for _, n := range next {
+		walk(n, nil, links)
+	}
+}
+
+// TypeName returns the type of err as formatted by %T. An error standing in
+// for another one, such as a redacted copy, names the type of the original
+// with a TypeName() string method.
+func TypeName(err error) string {
+	if t, ok := err.(interface{ TypeName() string }); ok {
+		return t.TypeName()
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// Unwrap returns the errors directly wrapped by err.
+func Unwrap(err error) []error {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	case interface{ Unwrap() error }:
+		if u := e.Unwrap(); u != nil {
+			return []error{u}
+		}
+	case interface{ Cause() error }:
+		if c := e.Cause(); c != nil && c != err {
+			return []error{c}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package errchain
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func messages(links []Link) []string {
+	var msgs []string
+	for _, l := range links {
+		msgs = append(msgs, l.Err.Error())
+	}
+	return msgs
+}
+
+func TestChainWrapped(t *testing.T) {
+	root := errors.New("root")
+	err := fmt.Errorf("outer: %w", fmt.Errorf("middle: %w", root))
+
+	assert.Equal(t, []string{"outer: middle: root", "middle: root", "root"}, messages(Chain(err)))
+}
+
+func TestChainJoin(t *testing.T) {
+	a := fmt.Errorf("a: %w", errors.New("cause"))
+	b := errors.New("b")
+	err := fmt.Errorf("failed: %w", errors.Join(a, b))
+
+	links := Chain(err)
+	assert.Equal(t, []string{"failed: a: cause\nb", "a: cause\nb", "a: cause", "cause", "b"}, messages(links))
+}
+
+func TestChainMergesStackLayers(t *testing.T) {
+	root := errors.New("root")
+	err := pkgerrors.Wrap(root, "wrapped")
+
+	links := Chain(err)
+	assert.Equal(t, []string{"wrapped: root", "root"}, messages(links))
+	// the withStack layer is merged into the withMessage below it
+	assert.Len(t, links[0].Layers, 2)
+	_, ok := links[0].Layers[0].(interface{ StackTrace() pkgerrors.StackTrace })
+	assert.True(t, ok)
+}
+
+func TestChainNil(t *testing.T) {
+	assert.Empty(t, Chain(nil))
+}
+
+type namedErr struct{ error }
+
+func (e namedErr) TypeName() string { return "*pkg.Original" }
+
+func TestTypeName(t *testing.T) {
+	assert.Equal(t, "*errors.errorString", TypeName(errors.New("boom")))
+	assert.Equal(t, "*pkg.Original", TypeName(namedErr{errors.New("boom")}))
+}
@@ -0,0 +1,100 @@
+// Package errhandler provides the handlers hooks report delivery failures to.
+package errhandler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorHandler is called when a hook fails to deliver an event. The event is
+// the backend specific payload, e.g. a *sentry.Event or *gobrake.Notice, or
+// the *logrus.Entry when no payload was built yet.
+type ErrorHandler interface {
+	HandleError(hook string, event interface{}, err error)
+}
+
+// Func adapts a function to the ErrorHandler interface.
+type Func func(hook string, event interface{}, err error)
+
+// HandleError calls f.
+func (f Func) HandleError(hook string, event interface{}, err error) {
+	f(hook, event, err)
+}
+
+// Stderr writes every error to os.Stderr. It is the default handler of the
+// hooks.
+var Stderr ErrorHandler = NewWriter(os.Stderr)
+
+// NewWriter returns a handler that writes every error to w.
+func NewWriter(w io.Writer) ErrorHandler {
+	var mu sync.Mutex
+	return Func(func(hook string, event interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "Failed to send event to %s: %v\n", hook, err)
+	})
+}
+
+// NewLogger returns a handler that logs every error to the fallback logger.
+// The fallback logger's hooks are not fired, so a failing hook attached to
+// it cannot recurse into itself.
+func NewLogger(fallback *logrus.Logger) ErrorHandler {
+	logger := &logrus.Logger{
+		Out:          fallback.Out,
+		Formatter:    fallback.Formatter,
+		Hooks:        make(logrus.LevelHooks),
+		Level:        fallback.Level,
+		ExitFunc:     fallback.ExitFunc,
+		ReportCaller: fallback.ReportCaller,
+	}
+	return Func(func(hook string, event interface{}, err error) {
+		logger.WithFields(logrus.Fields{
+			"hook":          hook,
+			logrus.ErrorKey: err,
+		}).Error("Failed to send event")
+	})
+}
+
+// Counter counts the errors per hook and passes them on to Next, if set.
+type Counter struct {
+	Next ErrorHandler
+
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// HandleError counts err for hook.
+func (c *Counter) HandleError(hook string, event interface{}, err error) {
+	c.mu.Lock()
+	if c.counts == nil {
+		c.counts = make(map[string]int64)
+	}
+	c.counts[hook]++
+	c.mu.Unlock()
+
+	if c.Next != nil {
+		c.Next.HandleError(hook, event, err)
+	}
+}
+
+// Count returns the number of errors handled for hook.
+func (c *Counter) Count(hook string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[hook]
+}
+
+// Counts returns the number of errors handled per hook.
+func (c *Counter) Counts() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int64, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
@@ -0,0 +1,60 @@
+package errhandler
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type firedHook struct {
+	fired int
+}
+
+func (h *firedHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *firedHook) Fire(*logrus.Entry) error {
+	h.fired++
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewWriter(buf).HandleError("sentry", nil, errors.New("boom"))
+	assert.Equal(t, "Failed to send event to sentry: boom\n", buf.String())
+}
+
+func TestLoggerDoesNotFireHooks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	hook := &firedHook{}
+	fallback := logrus.New()
+	fallback.Out = buf
+	fallback.AddHook(hook)
+
+	NewLogger(fallback).HandleError("sentry", nil, errors.New("boom"))
+
+	assert.Equal(t, 0, hook.fired)
+	assert.True(t, strings.Contains(buf.String(), "hook=sentry"), buf.String())
+	assert.True(t, strings.Contains(buf.String(), "error=boom"), buf.String())
+}
+
+func TestCounter(t *testing.T) {
+	var next []string
+	c := &Counter{Next: Func(func(hook string, event interface{}, err error) {
+		next = append(next, hook)
+	})}
+
+	c.HandleError("sentry", nil, errors.New("boom"))
+	c.HandleError("sentry", nil, errors.New("boom"))
+	c.HandleError("airbrake", nil, errors.New("boom"))
+
+	assert.Equal(t, int64(2), c.Count("sentry"))
+	assert.Equal(t, int64(0), c.Count("other"))
+	assert.Equal(t, map[string]int64{"sentry": 2, "airbrake": 1}, c.Counts())
+	assert.Equal(t, []string{"sentry", "sentry", "airbrake"}, next)
+}
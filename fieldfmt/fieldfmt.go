@@ -0,0 +1,35 @@
+// Package fieldfmt formats the values of log fields for the JSON payloads of
+// the hooks, so that every hook sends a field the same way.
+package fieldfmt
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Format returns value in the form it is sent in: json.Marshaler values as
+// they are, errors as their message, fmt.Stringer values as their string,
+// and any other value as it is.
+func Format(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Marshaler:
+		return value
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return value
+	}
+}
+
+// FormatJSON returns Format(value), or its %+v representation when that
+// cannot be encoded to JSON, e.g. because it holds a channel or a function,
+// so that one field cannot make a whole payload fail to encode.
+func FormatJSON(value interface{}) interface{} {
+	formatted := Format(value)
+	if _, err := json.Marshal(formatted); err != nil {
+		return fmt.Sprintf("%+v", value)
+	}
+	return formatted
+}
@@ -0,0 +1,28 @@
+package fieldfmt
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, now, Format(now), "json.Marshaler values should be kept")
+	assert.Equal(t, "boom", Format(errors.New("boom")))
+	assert.Equal(t, "10.0.0.1", Format(net.IPv4(10, 0, 0, 1)))
+	assert.Equal(t, 42, Format(42))
+}
+
+func TestFormatJSON(t *testing.T) {
+	assert.Equal(t, 42, FormatJSON(42))
+	assert.Equal(t, map[string]int{"n": 1}, FormatJSON(map[string]int{"n": 1}))
+	assert.IsType(t, "", FormatJSON(make(chan int)))
+	assert.Equal(t, "{N:1 C:<nil>}", FormatJSON(struct {
+		N int
+		C chan int
+	}{N: 1}))
+}
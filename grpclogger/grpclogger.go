@@ -0,0 +1,196 @@
+// Package grpclogger provides gRPC interceptors that give every call a
+// request scoped logrus entry, see logrus_hooks.FromContext, and report the
+// calls that fail to the hooks of a logger.
+package grpclogger
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	logrus_hooks "github.com/CIP-NL/logrus-hooks"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Fields added to the entries of a call, holding strings. The hooks decide
+// how to send them: by default Sentry sends FieldMethod and FieldCode as
+// tags and the other fields in the "grpc" context, see
+// sentry.DefaultTagFields and sentry.DefaultContextPrefixes, and Airbrake
+// sends all but the metadata as context keys, see
+// airbrake.DefaultContextFields.
+const (
+	FieldMethod   = "grpc.method"
+	FieldCode     = "grpc.code"
+	FieldPeer     = "grpc.peer"
+	FieldTarget   = "grpc.target"
+	FieldDeadline = "grpc.deadline"
+	// FieldMetadata prefixes the metadata keys selected by Options.Metadata.
+	FieldMetadata = "grpc.metadata."
+)
+
+// DefaultErrorCodes are the codes reported when Options.ErrorCodes is not
+// set: the codes of the faults of the server. Unknown covers the errors of
+// handlers that do not return a status.
+var DefaultErrorCodes = []codes.Code{
+	codes.Unknown,
+	codes.DeadlineExceeded,
+	codes.Unimplemented,
+	codes.Internal,
+	codes.Unavailable,
+	codes.DataLoss,
+}
+
+// Options configures the interceptors. The zero value is ready to use.
+type Options struct {
+	// Metadata are the metadata keys of incoming calls added as fields,
+	// e.g. "x-request-id". The values of a key are joined with commas.
+	Metadata []string
+	// ErrorCodes are the codes of the failed calls reported at ErrorLevel;
+	// the calls failing with another code, such as NotFound or
+	// InvalidArgument, are logged at InfoLevel. Defaults to
+	// DefaultErrorCodes.
+	ErrorCodes []codes.Code
+}
+
+func (o Options) level(code codes.Code) logrus.Level {
+	errorCodes := o.ErrorCodes
+	if errorCodes == nil {
+		errorCodes = DefaultErrorCodes
+	}
+	for _, c := range errorCodes {
+		if c == code {
+			return logrus.ErrorLevel
+		}
+	}
+	return logrus.InfoLevel
+}
+
+// UnaryServerInterceptor returns an interceptor storing an entry of logger,
+// carrying the fields of the call, in the context of every call. Failed
+// calls are logged with their error and code. A panic of the handler is
+// reported like logrus_hooks.ReportPanic does and the call fails with
+// codes.Internal.
+func UnaryServerInterceptor(logger *logrus.Logger, opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx = newServerContext(ctx, logger, info.FullMethod, opts)
+		defer recoverCall(ctx, &err)
+		resp, err = handler(ctx, req)
+		logResult(logrus_hooks.FromContext(ctx), err, opts)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(logger *logrus.Logger, opts Options) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		ctx := newServerContext(ss.Context(), logger, info.FullMethod, opts)
+		defer recoverCall(ctx, &err)
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		logResult(logrus_hooks.FromContext(ctx), err, opts)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor logging the failed calls of
+// a client with an entry of logger carrying the method and target.
+func UnaryClientInterceptor(logger *logrus.Logger, opts Options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		logResult(clientEntry(ctx, logger, method, cc), err, opts)
+		return err
+	}
+}
+
+// StreamClientInterceptor is the streaming counterpart of
+// UnaryClientInterceptor. A stream is logged when it cannot be opened or
+// when receiving fails with an error other than io.EOF.
+func StreamClientInterceptor(logger *logrus.Logger, opts Options) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		entry := clientEntry(ctx, logger, method, cc)
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			logResult(entry, err, opts)
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, entry: entry, opts: opts}, nil
+	}
+}
+
+// newServerContext returns ctx carrying the entry of a call to method.
+func newServerContext(ctx context.Context, logger *logrus.Logger, method string, opts Options) context.Context {
+	fields := logrus.Fields{FieldMethod: method}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields[FieldPeer] = p.Addr.String()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		fields[FieldDeadline] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range opts.Metadata {
+			if values := md.Get(key); len(values) > 0 {
+				fields[FieldMetadata+strings.ToLower(key)] = strings.Join(values, ",")
+			}
+		}
+	}
+	return logrus_hooks.NewContext(ctx, logger.WithFields(fields))
+}
+
+// clientEntry returns the entry of a call to method on cc.
+func clientEntry(ctx context.Context, logger *logrus.Logger, method string, cc *grpc.ClientConn) *logrus.Entry {
+	fields := logrus.Fields{FieldMethod: method, FieldTarget: cc.Target()}
+	if deadline, ok := ctx.Deadline(); ok {
+		fields[FieldDeadline] = deadline.UTC().Format(time.RFC3339Nano)
+	}
+	return logger.WithFields(fields)
+}
+
+// recoverCall recovers a panic of a handler, reports it with the entry of
+// ctx and fails the call.
+func recoverCall(ctx context.Context, err *error) {
+	if r := recover(); r != nil {
+		logrus_hooks.ReportPanic(logrus_hooks.FromContext(ctx), r)
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+// logResult logs err, if any, at the level of its code.
+func logResult(entry *logrus.Entry, err error, opts Options) {
+	if err == nil {
+		return
+	}
+	code := status.Code(err)
+	entry.WithError(err).WithField(FieldCode, code.String()).
+		Log(opts.level(code), "call failed with code ", code)
+}
+
+// serverStream overrides the context of a server stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// clientStream logs the error ending a client stream.
+type clientStream struct {
+	grpc.ClientStream
+	entry *logrus.Entry
+	opts  Options
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && err != io.EOF {
+		logResult(s.entry, err, s.opts)
+	}
+	return err
+}
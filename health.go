@@ -0,0 +1,105 @@
+package logrus_hooks
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+)
+
+// HealthHook is implemented by hooks that report their delivery health.
+type HealthHook interface {
+	logrus.Hook
+	Health() stats.Health
+}
+
+// healthOf returns the health of hook, if it reports it.
+func healthOf(hook logrus.Hook) (stats.Health, bool) {
+	if h, ok := hook.(HealthHook); ok {
+		return h.Health(), true
+	}
+	return stats.Health{}, false
+}
+
+// HookHealth is the health of a hook as reported by the HealthHandler.
+type HookHealth struct {
+	stats.Health
+	Healthy  bool `json:"healthy"`
+	Critical bool `json:"critical"`
+}
+
+// HealthReport is the JSON body written by the HealthHandler.
+type HealthReport struct {
+	// Status is "ok", or "unavailable" when a critical hook is unhealthy.
+	Status string                `json:"status"`
+	Hooks  map[string]HookHealth `json:"hooks"`
+}
+
+// HealthHandler is an http.Handler reporting the health of hooks as JSON.
+// It responds with 503 Service Unavailable when a critical hook is
+// unhealthy and with 200 OK otherwise.
+type HealthHandler struct {
+	hooks    map[string]logrus.Hook
+	critical map[string]bool
+}
+
+// NewHealthHandler returns a HealthHandler for the hooks returned by
+// GenerateHooks. Criticality is read from the hook configuration.
+func NewHealthHandler(conf []Hook, hooks map[string]logrus.Hook) *HealthHandler {
+	critical := make(map[string]bool, len(conf))
+	for _, h := range conf {
+		critical[h.Name] = h.Critical
+	}
+	return &HealthHandler{hooks: hooks, critical: critical}
+}
+
+// LoggerHooks returns the hooks of loggers that report their health, keyed
+// by hook name. It recovers the hooks of the loggers returned by
+// GenerateLoggers for NewHealthHandler.
+func LoggerHooks(loggers map[string]*logrus.Logger) map[string]logrus.Hook {
+	hooks := make(map[string]logrus.Hook)
+	for _, logger := range loggers {
+		for _, lh := range logger.Hooks {
+			for _, hook := range lh {
+				if h, ok := healthOf(hook); ok {
+					hooks[h.Hook] = hook
+				}
+			}
+		}
+	}
+	return hooks
+}
+
+// Report returns the health of all hooks.
+func (hh *HealthHandler) Report() HealthReport {
+	report := HealthReport{Status: "ok", Hooks: make(map[string]HookHealth)}
+	for name, hook := range hh.hooks {
+		h, ok := healthOf(hook)
+		if !ok {
+			continue
+		}
+		hookHealth := HookHealth{
+			Health:   h,
+			Healthy:  h.Healthy(),
+			Critical: hh.critical[name],
+		}
+		if hookHealth.Critical && !hookHealth.Healthy {
+			report.Status = "unavailable"
+		}
+		report.Hooks[name] = hookHealth
+	}
+	return report
+}
+
+// ServeHTTP writes the health report.
+func (hh *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := hh.Report()
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
+}
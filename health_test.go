@@ -0,0 +1,61 @@
+package logrus_hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type healthRecordingHook struct {
+	recordingHook
+	health stats.Health
+}
+
+func (h *healthRecordingHook) Health() stats.Health {
+	return h.health
+}
+
+func serveHealth(hh *HealthHandler) (int, HealthReport) {
+	rec := httptest.NewRecorder()
+	hh.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	var report HealthReport
+	json.NewDecoder(rec.Body).Decode(&report)
+	return rec.Code, report
+}
+
+func TestHealthHandler(t *testing.T) {
+	sentryHook := &healthRecordingHook{health: stats.Health{Hook: "sentry", Circuit: stats.CircuitClosed}}
+	airbrakeHook := &healthRecordingHook{health: stats.Health{Hook: "airbrake", Circuit: stats.CircuitOpen}}
+	hooks := map[string]logrus.Hook{
+		"sentry":   NewDedupHook(sentryHook, 0),
+		"airbrake": airbrakeHook,
+	}
+	conf := []Hook{{Name: "sentry", Critical: true}, {Name: "airbrake"}}
+
+	code, report := serveHealth(NewHealthHandler(conf, hooks))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", report.Status)
+	assert.True(t, report.Hooks["sentry"].Healthy)
+	assert.True(t, report.Hooks["sentry"].Critical)
+	assert.False(t, report.Hooks["airbrake"].Healthy)
+
+	sentryHook.health.ConsecutiveFailures = stats.MaxConsecutiveFailures
+	code, report = serveHealth(NewHealthHandler(conf, hooks))
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", report.Status)
+}
+
+func TestLoggerHooks(t *testing.T) {
+	hook := &healthRecordingHook{health: stats.Health{Hook: "sentry"}}
+	log := newTestLogger(NewSampleHook(hook, nil, ""))
+	log.AddHook(&EntryCounter{})
+
+	hooks := LoggerHooks(map[string]*logrus.Logger{"api_logger": log})
+	assert.Len(t, hooks, 1)
+	assert.Contains(t, hooks, "sentry")
+}
@@ -0,0 +1,100 @@
+// Package httpreq holds the representation of the HTTP request of an entry
+// that the Sentry and Airbrake hooks share, so that neither hook depends on
+// the other.
+package httpreq
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Field is the field the hooks read the request of an entry from. It holds
+// a *http.Request, a Value or a *Request.
+const Field = "http_request"
+
+// Value holds a *http.Request as the value of a field. Formatters print it as
+// the method and URL of the request, without the query, instead of dumping
+// the whole request or failing to encode it; the hooks read the request
+// itself, see HTTPRequest.
+type Value struct {
+	Request *http.Request
+}
+
+// String returns the method and the URL of the request, without the query.
+func (v Value) String() string {
+	if v.Request == nil || v.Request.URL == nil {
+		return ""
+	}
+	u := *v.Request.URL
+	u.RawQuery, u.ForceQuery = "", false
+	return v.Request.Method + " " + u.String()
+}
+
+// MarshalJSON encodes v as its String.
+func (v Value) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+// HTTPRequest returns the *http.Request held by value, either directly or in
+// a Value.
+func HTTPRequest(value interface{}) (*http.Request, bool) {
+	switch value := value.(type) {
+	case *http.Request:
+		return value, value != nil
+	case Value:
+		return value.Request, value.Request != nil
+	}
+	return nil, false
+}
+
+// Request is a snapshot of the HTTP request an entry was logged in, in the
+// form of the request of a Sentry event.
+type Request struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Query   string            `json:"query_string,omitempty"`
+	Cookies string            `json:"cookies,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// Data must be either a string or a map[string]string.
+	Data interface{} `json:"data,omitempty"`
+}
+
+// querySecretFields are the query parameters New masks, matched as
+// substrings of their names.
+var querySecretFields = []string{"password", "passphrase", "passwd", "secret"}
+
+// New returns req as a Request. Query parameters that look like secrets are
+// masked; the body is not read.
+func New(req *http.Request) *Request {
+	proto := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		proto = "https"
+	}
+	query := req.URL.Query()
+	for field := range query {
+		for _, keyword := range querySecretFields {
+			if strings.Contains(field, keyword) {
+				query[field] = []string{"********"}
+				break
+			}
+		}
+	}
+	r := &Request{
+		Method:  req.Method,
+		Cookies: req.Header.Get("Cookie"),
+		Query:   url.Values(query).Encode(),
+		URL:     proto + "://" + req.Host + req.URL.Path,
+		Headers: make(map[string]string, len(req.Header)),
+	}
+	if addr, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		r.Env = map[string]string{"REMOTE_ADDR": addr, "REMOTE_PORT": port}
+	}
+	for k, v := range req.Header {
+		r.Headers[k] = strings.Join(v, ",")
+	}
+	return r
+}
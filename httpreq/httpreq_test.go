@@ -0,0 +1,22 @@
+package httpreq
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	req := httptest.NewRequest("POST", "https://example.com/login?user=jane&password=hunter2", nil)
+	req.Header.Set("User-Agent", "test")
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	r := New(req)
+	assert.Equal(t, "https://example.com/login", r.URL)
+	assert.Equal(t, "POST", r.Method)
+	assert.Equal(t, "password=%2A%2A%2A%2A%2A%2A%2A%2A&user=jane", r.Query)
+	assert.Equal(t, "test", r.Headers["User-Agent"])
+	assert.Equal(t, map[string]string{"REMOTE_ADDR": "10.0.0.1", "REMOTE_PORT": "1234"}, r.Env)
+	assert.Equal(t, "hunter2", req.URL.Query().Get("password"), "the request should be left untouched")
+}
@@ -1,7 +1,10 @@
 package logrus_hooks
 
 import (
+	"time"
+
 	"github.com/CIP-NL/logrus-hooks/airbrake"
+	"github.com/CIP-NL/logrus-hooks/errhandler"
 	"github.com/CIP-NL/logrus-hooks/sentry"
 	"github.com/sirupsen/logrus"
 )
@@ -16,6 +19,52 @@ type Hook struct {
 	Kind        string `toml:"kind,omitempty"`
 	DNS         string `toml:"dns,omitempty"`
 	Level       string `toml:"level,omitempty"`
+	// Release and ServerName override the defaults derived from the build
+	// and the host, see the buildinfo package.
+	Release    string `toml:"release,omitempty"`
+	ServerName string `toml:"server_name,omitempty"`
+	// Critical hooks make the health handler report unavailable when they
+	// are unhealthy.
+	Critical bool `toml:"critical,omitempty"`
+	// Breadcrumbs is the number of entries below the hook's level that are
+	// attached to the next event. Sentry only.
+	Breadcrumbs int `toml:"breadcrumbs,omitempty"`
+	// TagFields are the fields sent as tags rather than extra data. Sentry
+	// only.
+	TagFields []string `toml:"tag_fields,omitempty"`
+	// Stacktrace configures the stack traces of the events. Sentry only.
+	Stacktrace StacktraceConfig `toml:"stacktrace,omitempty"`
+	// Workers and QueueSize configure an async hook. Airbrake only.
+	Workers   int `toml:"workers,omitempty"`
+	QueueSize int `toml:"queue_size,omitempty"`
+
+	// DedupWindow enables duplicate suppression when set, e.g. "30s".
+	DedupWindow string `toml:"dedup_window,omitempty"`
+	// Rate enables rate limiting when set, in entries per second.
+	Rate     float64 `toml:"rate,omitempty"`
+	Burst    int     `toml:"burst,omitempty"`
+	KeyField string  `toml:"key_field,omitempty"`
+	// GlobalRate caps the entries of all keys together when set.
+	GlobalRate  float64 `toml:"global_rate,omitempty"`
+	GlobalBurst int     `toml:"global_burst,omitempty"`
+	// SampleRates maps a level alias to the fraction of its entries to send.
+	SampleRates map[string]float64 `toml:"sample_rates,omitempty"`
+	SampleField string             `toml:"sample_field,omitempty"`
+}
+
+// StacktraceConfig configures the stack traces of a sentry hook, see
+// sentry.StackTraceConfiguration.
+type StacktraceConfig struct {
+	Enable bool `toml:"enable,omitempty"`
+	// Level is the least severe level stack traces are captured at, e.g.
+	// "ERROR". Defaults to ERROR.
+	Level string `toml:"level,omitempty"`
+	// Context is the number of source lines sent around each frame.
+	Context int `toml:"context,omitempty"`
+	// SendExceptionType defaults to true.
+	SendExceptionType *bool `toml:"send_exception_type,omitempty"`
+	// InAppPrefixes default to the path of the main module.
+	InAppPrefixes []string `toml:"in_app_prefixes,omitempty"`
 }
 
 type Loggers []struct {
@@ -24,11 +73,18 @@ type Loggers []struct {
 	Hooks []struct {
 		Name string `toml:"name"`
 	}
+	Redact RedactConfig `toml:"redact,omitempty"`
 }
 
 type Logrus struct {
 	Hooks   []Hook  `toml:"hooks"`
 	Loggers Loggers `toml:"loggers"`
+	// ErrorHandler is the name of the logger hook delivery failures are
+	// logged to. They are written to stderr when it is empty.
+	ErrorHandler string `toml:"error_handler,omitempty"`
+	// OnPanic is what RecoverAndReport does after reporting a panic:
+	// "repanic", the default, or "exit".
+	OnPanic string `toml:"on_panic,omitempty"`
 }
 
 // Configuration is just a wrapper used during tests.
@@ -43,9 +99,9 @@ func GenerateHooks(hooks []Hook) map[string]logrus.Hook {
 		if h.Backup == "" {
 			switch h.Type {
 			case "sentry":
-				hks[h.Name] = genSentryHook(h)
+				hks[h.Name] = wrapHook(h, genSentryHook(h))
 			case "airbrake":
-				hks[h.Name] = genAirbrakeHook(h)
+				hks[h.Name] = wrapHook(h, genAirbrakeHook(h))
 			}
 		}
 	}
@@ -54,9 +110,9 @@ func GenerateHooks(hooks []Hook) map[string]logrus.Hook {
 		if h.Backup != "" {
 			switch h.Type {
 			case "sentry":
-				hks[h.Name] = genSentryHook(h, hks[h.Backup])
+				hks[h.Name] = wrapHook(h, genSentryHook(h, hks[h.Backup]))
 			case "airbrake":
-				hks[h.Name] = genAirbrakeHook(h, hks[h.Backup])
+				hks[h.Name] = wrapHook(h, genAirbrakeHook(h, hks[h.Backup]))
 			}
 		}
 	}
@@ -71,29 +127,81 @@ func GenerateLoggers(log Logrus) map[string]*logrus.Logger {
 		logger := logrus.New()
 		lvl := getLevelFromString(l.Level)
 		logger.SetLevel(lvl)
+		logger.AddHook(&EntryCounter{Logger: l.Name})
 
+		redactor := genRedactor(l.Redact)
 		if len(l.Hooks) > 0 {
 			for _, x := range l.Hooks {
-				logger.AddHook(hks[x.Name])
+				if redactor != nil {
+					logger.AddHook(redactor.Wrap(hks[x.Name]))
+				} else {
+					logger.AddHook(hks[x.Name])
+				}
 			}
 		}
 		loggers[l.Name] = logger
 	}
+
+	if log.ErrorHandler != "" {
+		fallback, ok := loggers[log.ErrorHandler]
+		if !ok {
+			panic("Unable to find error handler logger: " + log.ErrorHandler)
+		}
+		handler := errhandler.NewLogger(fallback)
+		for _, hk := range hks {
+			setErrorHandler(hk, handler)
+		}
+	}
+	if log.OnPanic != "" {
+		OnPanic = getPanicAction(log.OnPanic)
+	}
 	return loggers
 }
 
+// errorHandlerSetter is implemented by hooks that report delivery failures.
+type errorHandlerSetter interface {
+	SetErrorHandler(handler errhandler.ErrorHandler)
+}
+
+// setErrorHandler sets the error handler of hook, if it has one.
+func setErrorHandler(hook logrus.Hook, handler errhandler.ErrorHandler) {
+	if h, ok := hook.(errorHandlerSetter); ok {
+		h.SetErrorHandler(handler)
+	}
+}
+
 func genAirbrakeHook(h Hook, backups ...logrus.Hook) logrus.Hook {
-	return airbrake.NewHook(h.ProjectID, h.APIKey, h.Environment)
+	var hook *airbrake.Hook
+
+	switch h.Kind {
+	case "", "default":
+		hook = airbrake.NewHook(h.ProjectID, h.APIKey, h.Environment)
+	case "async":
+		hook = airbrake.NewAsyncHook(h.ProjectID, h.APIKey, h.Environment, airbrake.AsyncConfig{
+			Workers:   h.Workers,
+			QueueSize: h.QueueSize,
+		})
+	default:
+		panic("Did not recognise hook kind for hook: " + h.Name)
+	}
+	hook.Name = h.Name
+	if h.Release != "" {
+		hook.SetRelease(h.Release)
+	}
+	if h.ServerName != "" {
+		hook.SetServerName(h.ServerName)
+	}
+	return hook
 }
 
 func genSentryHook(h Hook, backups ...logrus.Hook) logrus.Hook {
-	var hook logrus.Hook
+	var hook *sentry.Hook
 	var err error
 
 	levels := getLevelFromHook(h)
 
 	switch h.Kind {
-	case "default":
+	case "", "default":
 		hook = sentry.New(h.DNS)
 	case "async":
 		hook, err = sentry.NewAsyncHook(h.DNS, levels)
@@ -103,13 +211,75 @@ func genSentryHook(h Hook, backups ...logrus.Hook) logrus.Hook {
 	if err != nil {
 		panic("Unable to create hook: " + h.Name + err.Error())
 	}
+	if hook == nil {
+		panic("Unable to create hook: " + h.Name)
+	}
+	hook.Name = h.Name
+	if h.Release != "" {
+		hook.SetRelease(h.Release)
+	}
+	if h.Environment != "" {
+		hook.SetEnvironment(h.Environment)
+	}
+	if h.ServerName != "" {
+		hook.SetServerName(h.ServerName)
+	}
+	if h.Breadcrumbs > 0 {
+		hook.EnableBreadcrumbs(h.Breadcrumbs, nil)
+	}
+	for _, field := range h.TagFields {
+		hook.AddTagField(field)
+	}
+	configureStacktrace(&hook.StacktraceConfiguration, h.Stacktrace)
+	return hook
+}
+
+// configureStacktrace applies the settings of c that are set to st.
+func configureStacktrace(st *sentry.StackTraceConfiguration, c StacktraceConfig) {
+	st.Enable = c.Enable
+	if c.Level != "" {
+		st.Level = getLevelFromString(c.Level)
+	}
+	if c.Context != 0 {
+		st.Context = c.Context
+	}
+	if c.SendExceptionType != nil {
+		st.SendExceptionType = *c.SendExceptionType
+	}
+	if len(c.InAppPrefixes) > 0 {
+		st.InAppPrefixes = c.InAppPrefixes
+	}
+}
+
+// wrapHook applies the optional wrappers configured for h around hook.
+// Entries are sampled first, then deduplicated and finally rate limited.
+func wrapHook(h Hook, hook logrus.Hook) logrus.Hook {
+	if h.Rate > 0 {
+		limit := NewRateLimitHook(hook, h.Rate, h.Burst, h.KeyField)
+		limit.GlobalRate = h.GlobalRate
+		limit.GlobalBurst = h.GlobalBurst
+		limit.Name = h.Name
+		hook = limit
+	}
+	if h.DedupWindow != "" {
+		window, err := time.ParseDuration(h.DedupWindow)
+		if err != nil {
+			panic("Unable to parse dedup_window for hook: " + h.Name + err.Error())
+		}
+		dedup := NewDedupHook(hook, window)
+		dedup.Name = h.Name
+		hook = dedup
+	}
+	if len(h.SampleRates) > 0 {
+		hook = NewSampleHook(hook, getSampleRates(h), h.SampleField)
+	}
 	return hook
 }
 
 // Helper function to convert levels to []logrus levels.
 // Allowed aliases: DEBUG, INFO, WARN, ERROR, CRITICAL
 func getLevelFromHook(h Hook) []logrus.Level {
-	lvl := []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel, logrus.FatalLevel}
+	lvl := []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel}
 
 	switch h.Level {
 	case "DEBUG":
@@ -146,3 +316,15 @@ func getLevelFromString(s string) logrus.Level {
 		panic("Unable to determine logging level from string: " + s)
 	}
 }
+
+// Helper function to convert the on_panic setting to a PanicAction.
+func getPanicAction(s string) PanicAction {
+	switch s {
+	case "repanic":
+		return RePanic
+	case "exit":
+		return Exit
+	default:
+		panic("Unable to determine panic action from string: " + s)
+	}
+}
@@ -1,6 +1,7 @@
 package logrus_hooks
 
 import (
+	"github.com/CIP-NL/logrus-hooks/sentry"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -35,3 +36,41 @@ func TestGenerateLoggers(t *testing.T) {
 	assert.Equal(t, logrus.FatalLevel, loggers["store_logger"].Level)
 
 }
+
+func TestConfigureStacktrace(t *testing.T) {
+	st := sentry.StackTraceConfiguration{
+		Level:             logrus.ErrorLevel,
+		SendExceptionType: true,
+		InAppPrefixes:     []string{"example.com/app"},
+	}
+	configureStacktrace(&st, StacktraceConfig{Enable: true, Context: 3})
+	assert.True(t, st.Enable)
+	assert.Equal(t, logrus.ErrorLevel, st.Level)
+	assert.Equal(t, 3, st.Context)
+	assert.True(t, st.SendExceptionType)
+	assert.Equal(t, []string{"example.com/app"}, st.InAppPrefixes)
+
+	send := false
+	configureStacktrace(&st, StacktraceConfig{
+		Enable:            true,
+		Level:             "WARN",
+		SendExceptionType: &send,
+		InAppPrefixes:     []string{"example.com/lib"},
+	})
+	assert.Equal(t, logrus.WarnLevel, st.Level)
+	assert.False(t, st.SendExceptionType)
+	assert.Equal(t, []string{"example.com/lib"}, st.InAppPrefixes)
+}
+
+func TestGetPanicAction(t *testing.T) {
+	assert.Equal(t, RePanic, getPanicAction("repanic"))
+	assert.Equal(t, Exit, getPanicAction("exit"))
+	assert.Panics(t, func() { getPanicAction("ignore") })
+}
+
+func TestGenerateHooksDefaultKind(t *testing.T) {
+	for _, h := range c.Logrus.Hooks {
+		h.Kind = ""
+		assert.NotPanics(t, func() { GenerateHooks([]Hook{h}) }, "an empty kind should mean default for %s hooks", h.Type)
+	}
+}
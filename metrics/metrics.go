@@ -0,0 +1,100 @@
+// Package metrics exports the statistics of the generated loggers and their
+// hooks as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/CIP-NL/logrus-hooks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	entriesDesc = prometheus.NewDesc(
+		"logrus_entries_total",
+		"Number of entries logged, by logger and level.",
+		[]string{"logger", "level"}, nil)
+	sentDesc = prometheus.NewDesc(
+		"logrus_hook_events_sent_total",
+		"Number of events delivered by a hook.",
+		[]string{"hook"}, nil)
+	failedDesc = prometheus.NewDesc(
+		"logrus_hook_events_failed_total",
+		"Number of events a hook failed to deliver.",
+		[]string{"hook"}, nil)
+	droppedDesc = prometheus.NewDesc(
+		"logrus_hook_events_dropped_total",
+		"Number of events a hook discarded without a delivery attempt.",
+		[]string{"hook"}, nil)
+	retriedDesc = prometheus.NewDesc(
+		"logrus_hook_events_retried_total",
+		"Number of delivery attempts a hook retried.",
+		[]string{"hook"}, nil)
+	queueDepthDesc = prometheus.NewDesc(
+		"logrus_hook_queue_depth",
+		"Number of events waiting to be sent by a hook.",
+		[]string{"hook"}, nil)
+	inFlightDesc = prometheus.NewDesc(
+		"logrus_hook_in_flight",
+		"Number of deliveries in progress for a hook.",
+		[]string{"hook"}, nil)
+	fireDurationDesc = prometheus.NewDesc(
+		"logrus_hook_fire_duration_seconds",
+		"Time Fire blocks the logging goroutine.",
+		[]string{"hook"}, nil)
+)
+
+// Collector is a prometheus.Collector for loggers created by
+// logrus_hooks.GenerateLoggers.
+type Collector struct {
+	loggers map[string]*logrus.Logger
+}
+
+// NewCollector returns a Collector for the given loggers, keyed by name.
+func NewCollector(loggers map[string]*logrus.Logger) *Collector {
+	return &Collector{loggers: loggers}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- entriesDesc
+	ch <- sentDesc
+	ch <- failedDesc
+	ch <- droppedDesc
+	ch <- retriedDesc
+	ch <- queueDepthDesc
+	ch <- inFlightDesc
+	ch <- fireDurationDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]struct{})
+	for name, logger := range c.loggers {
+		if counts, ok := logrus_hooks.LoggerStats(logger); ok {
+			for lvl, n := range counts {
+				ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.CounterValue, float64(n), name, lvl.String())
+			}
+		}
+
+		for hook, s := range logrus_hooks.HookStats(logger) {
+			// hooks are shared between loggers, only report them once
+			if _, ok := seen[hook]; ok {
+				continue
+			}
+			seen[hook] = struct{}{}
+
+			ch <- prometheus.MustNewConstMetric(sentDesc, prometheus.CounterValue, float64(s.Sent), hook)
+			ch <- prometheus.MustNewConstMetric(failedDesc, prometheus.CounterValue, float64(s.Failed), hook)
+			ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(s.Dropped), hook)
+			ch <- prometheus.MustNewConstMetric(retriedDesc, prometheus.CounterValue, float64(s.Retried), hook)
+			ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(s.QueueDepth), hook)
+			ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(s.InFlight), hook)
+
+			buckets := make(map[float64]uint64, len(s.FireLatency.Buckets))
+			for i, b := range s.FireLatency.Buckets {
+				buckets[b] = s.FireLatency.Counts[i]
+			}
+			ch <- prometheus.MustNewConstHistogram(fireDurationDesc, s.FireLatency.Count, s.FireLatency.Sum, buckets, hook)
+		}
+	}
+}
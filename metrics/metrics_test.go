@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/CIP-NL/logrus-hooks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	loggers := logrus_hooks.GenerateLoggers(logrus_hooks.Logrus{
+		Loggers: logrus_hooks.Loggers{
+			{Name: "api_logger", Level: "INFO"},
+		},
+	})
+	logger := loggers["api_logger"]
+	logger.Out = ioutil.Discard
+	logger.Info("one")
+	logger.Info("two")
+	logger.Debug("not logged")
+
+	c := NewCollector(loggers)
+	expected := `
+# HELP logrus_entries_total Number of entries logged, by logger and level.
+# TYPE logrus_entries_total counter
+logrus_entries_total{level="info",logger="api_logger"} 2
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "logrus_entries_total")
+	assert.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	assert.NoError(t, reg.Register(c))
+}
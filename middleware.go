@@ -0,0 +1,154 @@
+package logrus_hooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/sirupsen/logrus"
+)
+
+// Fields added to the entries of a request by Middleware. The hooks send
+// FieldHTTPRequest as the request of the event, and the user fields set by
+// SetUser as its user. FieldHTTPRequest holds an httpreq.Value, which the
+// formatters print as the method and URL of the request.
+const (
+	FieldHTTPRequest = httpreq.Field
+	FieldRequestID   = "request_id"
+	FieldRoute       = "route"
+)
+
+// RequestIDHeader is the header the request ID is read from and written to.
+const RequestIDHeader = "X-Request-ID"
+
+type requestEntryKey struct{}
+
+// requestEntry is the entry of a request, shared by the handlers down the
+// chain so that fields added by one are seen by the others.
+type requestEntry struct {
+	mu    sync.Mutex
+	entry *logrus.Entry
+}
+
+// Middleware returns net/http middleware storing an entry of logger in the
+// context of every request, see FromContext. The entry carries the request,
+// its ID, taken from the X-Request-ID header or generated and echoed in the
+// response, and its route when the middleware wraps a ServeMux or a handler
+// registered on one. Handlers add the route and the authenticated user with
+// SetRoute and SetUser.
+//
+// A panic of a handler is reported like RecoverAndReport does, with the
+// fields of the request, and answered with 500 Internal Server Error; it is
+// not raised again, so OnPanic does not apply. http.ErrAbortHandler is
+// raised again without being reported.
+func Middleware(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			id := req.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			fields := logrus.Fields{FieldRequestID: id}
+			if route := routeOf(next, req); route != "" {
+				fields[FieldRoute] = route
+			}
+			req = req.WithContext(NewContext(req.Context(), logger.WithFields(fields)))
+			AddFields(req.Context(), logrus.Fields{FieldHTTPRequest: httpreq.Value{Request: req}})
+
+			defer func() {
+				if r := recover(); r != nil {
+					if r == http.ErrAbortHandler {
+						panic(r)
+					}
+					ReportPanic(FromContext(req.Context()), r)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// NewContext returns a copy of ctx carrying entry as the entry of a request,
+// for servers other than net/http. The entries returned by FromContext for
+// the new context start with the fields of entry.
+func NewContext(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, requestEntryKey{}, &requestEntry{entry: entry})
+}
+
+// routeOf returns the pattern of the ServeMux that routed req to the
+// middleware or, when next is a ServeMux, the pattern it routes req to.
+func routeOf(next http.Handler, req *http.Request) string {
+	if req.Pattern != "" {
+		return req.Pattern
+	}
+	if mux, ok := next.(*http.ServeMux); ok {
+		_, pattern := mux.Handler(req)
+		return pattern
+	}
+	return ""
+}
+
+// FromContext returns the entry of the request ctx belongs to, see
+// Middleware, or an entry of the standard logger outside of a request. The
+// entry carries ctx, so the hooks see its trace and context fields. Every
+// call returns a new entry, so the fields added to it are not shared with the
+// other entries of the request; use AddFields for that.
+func FromContext(ctx context.Context) *logrus.Entry {
+	re, ok := ctx.Value(requestEntryKey{}).(*requestEntry)
+	if !ok {
+		return logrus.NewEntry(logrus.StandardLogger()).WithContext(ctx)
+	}
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	return re.entry.WithFields(logrus.Fields{}).WithContext(ctx)
+}
+
+// AddFields adds fields to the entries returned by FromContext for the rest
+// of the request. It does nothing outside of a request.
+func AddFields(ctx context.Context, fields logrus.Fields) {
+	re, ok := ctx.Value(requestEntryKey{}).(*requestEntry)
+	if !ok {
+		return
+	}
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.entry = re.entry.WithFields(fields)
+}
+
+// SetRoute sets the route of the request, for routers other than ServeMux.
+func SetRoute(ctx context.Context, route string) {
+	AddFields(ctx, logrus.Fields{FieldRoute: route})
+}
+
+// User is the authenticated user of a request.
+type User struct {
+	ID       string
+	Username string
+	Email    string
+}
+
+// SetUser sets the authenticated user of the request. Its attributes are
+// added as the user_id, user_name and user_email fields, which Sentry reads
+// as the user of the event.
+func SetUser(ctx context.Context, user User) {
+	fields := logrus.Fields{}
+	for k, v := range map[string]string{"user_id": user.ID, "user_name": user.Username, "user_email": user.Email} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	AddFields(ctx, fields)
+}
+
+// newRequestID returns a random 128 bit request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
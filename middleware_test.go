@@ -0,0 +1,147 @@
+package logrus_hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	rec := &recordingHook{}
+	handler := Middleware(newTestLogger(rec))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SetUser(req.Context(), User{ID: "42", Email: "jane@example.com"})
+		FromContext(req.Context()).WithField("k", "v").Error("boom")
+		FromContext(req.Context()).Warn("again")
+	}))
+
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "abc", w.Header().Get(RequestIDHeader))
+	entries := rec.all()
+	if assert.Len(t, entries, 2) {
+		data := entries[0].Data
+		assert.Equal(t, "abc", data[FieldRequestID])
+		assert.Equal(t, "42", data["user_id"])
+		assert.Equal(t, "jane@example.com", data["user_email"])
+		assert.NotContains(t, data, "user_name")
+		assert.Equal(t, "v", data["k"])
+		if r, ok := httpreq.HTTPRequest(data[FieldHTTPRequest]); assert.True(t, ok) {
+			assert.Equal(t, "/items/1", r.URL.Path)
+		}
+		assert.NotContains(t, entries[1].Data, "k", "fields of one entry should not leak into the next")
+		assert.NotNil(t, entries[0].Context.Value(requestEntryKey{}), "entries should carry the context of the request")
+		assert.Equal(t, "42", entries[1].Data["user_id"])
+	}
+}
+
+func TestMiddlewareJSONFormatter(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.Out = &out
+	log.Formatter = &logrus.JSONFormatter{}
+	handler := Middleware(log)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).Error("boom")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1?token=abc", nil))
+
+	var line map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(out.Bytes(), &line), "the entry should be encoded: %s", out.String()) {
+		assert.Equal(t, "boom", line["msg"])
+		assert.Equal(t, "GET /items/1", line[FieldHTTPRequest])
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	rec := &recordingHook{}
+	handler := Middleware(newTestLogger(rec))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).Error("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	id := w.Header().Get(RequestIDHeader)
+	assert.Len(t, id, 32)
+	if entries := rec.all(); assert.Len(t, entries, 1) {
+		assert.Equal(t, id, entries[0].Data[FieldRequestID])
+	}
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(rec)
+	mux := http.NewServeMux()
+	mux.Handle("GET /items/{id}", Middleware(log)(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).Error("boom")
+		SetRoute(req.Context(), "items")
+		FromContext(req.Context()).Error("boom")
+	})))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+
+	if entries := rec.all(); assert.Len(t, entries, 2) {
+		assert.Equal(t, "GET /items/{id}", entries[0].Data[FieldRoute])
+		assert.Equal(t, "items", entries[1].Data[FieldRoute])
+	}
+}
+
+func TestMiddlewareWrappingMux(t *testing.T) {
+	rec := &recordingHook{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).Error("boom")
+	})
+
+	Middleware(newTestLogger(rec))(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+
+	if entries := rec.all(); assert.Len(t, entries, 1) {
+		assert.Equal(t, "GET /items/{id}", entries[0].Data[FieldRoute])
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	rec := &recordingHook{}
+	handler := Middleware(newTestLogger(rec))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	if entries := rec.all(); assert.Len(t, entries, 1) {
+		assert.Equal(t, logrus.PanicLevel, entries[0].Level)
+		assert.Equal(t, "abc", entries[0].Data[FieldRequestID])
+		assert.Contains(t, entries[0].Data, FieldHTTPRequest)
+	}
+
+	handler = Middleware(newTestLogger(rec))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+	assert.Len(t, rec.all(), 1, "aborted requests should not be reported")
+}
+
+func TestFromContextOutsideRequest(t *testing.T) {
+	ctx := context.Background()
+	AddFields(ctx, logrus.Fields{"k": "v"})
+	entry := FromContext(ctx)
+	assert.Equal(t, logrus.StandardLogger(), entry.Logger)
+	assert.Empty(t, entry.Data)
+	assert.Equal(t, ctx, entry.Context)
+}
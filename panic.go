@@ -0,0 +1,130 @@
+package logrus_hooks
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FieldPanic is the field holding the recovered value of the entry reported
+// by RecoverAndReport.
+const FieldPanic = "panic"
+
+// PanicAction is what RecoverAndReport does once it reported a panic.
+type PanicAction int
+
+const (
+	// RePanic panics again with the recovered value, so that the program
+	// crashes as it would have without RecoverAndReport.
+	RePanic PanicAction = iota
+	// Exit exits the program with status 2, the status of a crash caused by
+	// a panic, without printing the stack of every goroutine.
+	Exit
+)
+
+var (
+	// OnPanic is what RecoverAndReport does after reporting a panic. It is
+	// set by GenerateLoggers from the on_panic setting.
+	OnPanic = RePanic
+	// PanicFlushTimeout bounds the time RecoverAndReport waits for each hook
+	// that flushes with a timeout, such as a batch.Hook.
+	PanicFlushTimeout = 5 * time.Second
+
+	// exit is os.Exit, replaced during tests.
+	exit = os.Exit
+)
+
+// RecoverAndReport recovers a panic and reports it to the hooks of logger
+// before panicking again or exiting, see OnPanic. It must be deferred
+// directly:
+//
+//	defer logrus_hooks.RecoverAndReport(logger)
+//
+// The panic is logged as a PanicLevel entry, with the recovered value as its
+// error and under FieldPanic. The hooks see the stack of the panic, starting
+// at the frame that panicked. Asynchronous hooks, and the hooks wrapped by
+// this package, are flushed before RecoverAndReport returns control to the
+// runtime.
+func RecoverAndReport(logger *logrus.Logger) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	ReportPanic(logrus.NewEntry(logger), r)
+	switch OnPanic {
+	case Exit:
+		exit(2)
+	default:
+		panic(r)
+	}
+}
+
+// Go runs f in a new goroutine that reports a panic of f with
+// RecoverAndReport.
+func Go(logger *logrus.Logger, f func()) {
+	go func() {
+		defer RecoverAndReport(logger)
+		f()
+	}()
+}
+
+// ReportPanic logs the recovered value r at PanicLevel with the fields of
+// entry, as RecoverAndReport does, and flushes the hooks of its logger. It is
+// meant for servers that recover the panics of their handlers and keep
+// running.
+func ReportPanic(entry *logrus.Entry, r interface{}) {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("%v", r)
+	}
+	func() {
+		defer func() { recover() }()
+		entry.WithError(err).WithField(FieldPanic, r).Log(logrus.PanicLevel, "panic: ", err)
+	}()
+	flushLogger(entry.Logger)
+}
+
+// flusher is implemented by hooks that deliver entries in the background.
+type flusher interface {
+	Flush()
+}
+
+// timeoutFlusher is implemented by hooks that deliver entries in the
+// background and can give up flushing after a timeout.
+type timeoutFlusher interface {
+	FlushTimeout(timeout time.Duration) error
+}
+
+// flushLogger flushes the hooks of logger. Hooks firing for several levels
+// are flushed for each, which is harmless as the later flushes find nothing
+// to wait for.
+func flushLogger(logger *logrus.Logger) {
+	for _, hooks := range logger.Hooks {
+		for _, hook := range hooks {
+			flushHook(hook)
+		}
+	}
+}
+
+// flushHook flushes hook and then the hooks it wraps, so that the entries a
+// wrapper releases on flush are flushed as well.
+func flushHook(hook logrus.Hook) {
+	switch h := hook.(type) {
+	case timeoutFlusher:
+		h.FlushTimeout(PanicFlushTimeout)
+	case flusher:
+		h.Flush()
+	}
+	switch h := hook.(type) {
+	case *DedupHook:
+		flushHook(h.Hook)
+	case *RateLimitHook:
+		flushHook(h.Hook)
+	case *SampleHook:
+		flushHook(h.Hook)
+	case *redactHook:
+		flushHook(h.hook)
+	}
+}
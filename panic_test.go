@@ -0,0 +1,169 @@
+package logrus_hooks
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/callers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// siteHook records the call site of the entries it is fired with.
+type siteHook struct {
+	recordingHook
+	sites []runtime.Frame
+}
+
+func (h *siteHook) Fire(entry *logrus.Entry) error {
+	if stack, _ := callers.Stack(entry); len(stack) > 0 {
+		h.sites = append(h.sites, stack[0])
+	}
+	return h.recordingHook.Fire(entry)
+}
+
+// flushingHook counts its flushes.
+type flushingHook struct {
+	recordingHook
+	flushes int
+}
+
+func (h *flushingHook) Flush() {
+	h.flushes++
+}
+
+// timeoutFlushingHook records the timeouts it was flushed with.
+type timeoutFlushingHook struct {
+	flushingHook
+	timeouts []time.Duration
+}
+
+func (h *timeoutFlushingHook) FlushTimeout(timeout time.Duration) error {
+	h.timeouts = append(h.timeouts, timeout)
+	return nil
+}
+
+func TestRecoverAndReport(t *testing.T) {
+	for _, reportCaller := range []bool{false, true} {
+		hook := &siteHook{}
+		log := newTestLogger(hook)
+		log.ReportCaller = reportCaller
+
+		var line int
+		var recovered interface{}
+		func() {
+			defer func() { recovered = recover() }()
+			defer RecoverAndReport(log)
+			_, _, line, _ = runtime.Caller(0)
+			panic("boom")
+		}()
+
+		assert.Equal(t, "boom", recovered, "the panic should be raised again")
+		entries := hook.all()
+		if assert.Len(t, entries, 1) {
+			entry := entries[0]
+			assert.Equal(t, logrus.PanicLevel, entry.Level)
+			assert.Equal(t, "panic: boom", entry.Message)
+			assert.Equal(t, "boom", entry.Data[FieldPanic])
+			assert.EqualError(t, entry.Data[logrus.ErrorKey].(error), "boom")
+		}
+		if assert.Len(t, hook.sites, 1) {
+			assert.Equal(t, line+1, hook.sites[0].Line, "the stack should start where the panic was raised")
+		}
+	}
+}
+
+func TestRecoverAndReportRuntimeError(t *testing.T) {
+	hook := &siteHook{}
+	log := newTestLogger(hook)
+
+	var line int
+	func() {
+		defer func() { recover() }()
+		defer RecoverAndReport(log)
+		var m map[string]int
+		_, _, line, _ = runtime.Caller(0)
+		m["k"] = 1
+	}()
+
+	entries := hook.all()
+	if assert.Len(t, entries, 1) {
+		var rerr runtime.Error
+		assert.True(t, errors.As(entries[0].Data[logrus.ErrorKey].(error), &rerr), "the runtime error should be kept")
+	}
+	if assert.Len(t, hook.sites, 1) {
+		assert.Equal(t, line+1, hook.sites[0].Line)
+	}
+}
+
+func TestRecoverAndReportExit(t *testing.T) {
+	defer func(action PanicAction) { OnPanic, exit = action, os.Exit }(OnPanic)
+	var code int
+	exit = func(c int) { code = c }
+	OnPanic = Exit
+
+	hook := &flushingHook{}
+	log := newTestLogger(NewDedupHook(hook, time.Hour))
+
+	func() {
+		defer RecoverAndReport(log)
+		panic(errors.New("boom"))
+	}()
+
+	assert.Equal(t, 2, code)
+	assert.Len(t, hook.all(), 1)
+	assert.NotZero(t, hook.flushes, "hooks wrapped by a DedupHook should be flushed")
+}
+
+func TestRecoverAndReportFlushTimeout(t *testing.T) {
+	defer func(action PanicAction) { OnPanic, exit = action, os.Exit }(OnPanic)
+	exit = func(int) {}
+	OnPanic = Exit
+
+	hook := &timeoutFlushingHook{}
+	log := newTestLogger(hook)
+
+	func() {
+		defer RecoverAndReport(log)
+		panic(errors.New("boom"))
+	}()
+
+	assert.NotEmpty(t, hook.timeouts)
+	for _, timeout := range hook.timeouts {
+		assert.Equal(t, PanicFlushTimeout, timeout)
+	}
+	assert.Zero(t, hook.flushes, "Flush should not block when the hook can flush with a timeout")
+}
+
+func TestRecoverAndReportNoPanic(t *testing.T) {
+	hook := &flushingHook{}
+	log := newTestLogger(hook)
+
+	func() {
+		defer RecoverAndReport(log)
+	}()
+
+	assert.Empty(t, hook.all())
+	assert.Zero(t, hook.flushes)
+}
+
+func TestGo(t *testing.T) {
+	defer func(action PanicAction) { OnPanic, exit = action, os.Exit }(OnPanic)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	exit = func(int) { wg.Done() }
+	OnPanic = Exit
+
+	hook := &recordingHook{}
+	Go(newTestLogger(hook), func() { panic("boom") })
+	wg.Wait()
+
+	entries := hook.all()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "panic: boom", entries[0].Message)
+	}
+}
@@ -0,0 +1,310 @@
+package logrus_hooks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+)
+
+// FieldDroppedCount carries the number of entries a RateLimitHook dropped for
+// a key. It is added to the next entry forwarded for that key or, when the
+// key goes quiet, to a summary entry sent once the limit lifts.
+const FieldDroppedCount = "dropped_count"
+
+// maxRateLimitKeys bounds the number of buckets kept by a RateLimitHook.
+// Idle buckets are discarded once it is reached.
+const maxRateLimitKeys = 10000
+
+// RateLimitHook wraps a hook and limits the entries forwarded to it with a
+// token bucket. When KeyField is set every value of that field gets its own
+// bucket, so one noisy key cannot exhaust the limit of the others, and
+// GlobalRate caps the entries of all keys together.
+//
+// When entries of a key were dropped, the next entry forwarded for the key
+// carries their number in the dropped_count field. If no entry comes, a
+// summary entry built from the first dropped one carries it as soon as the
+// limit lifts.
+type RateLimitHook struct {
+	// Hook is the wrapped hook.
+	Hook logrus.Hook
+	// Rate is the number of entries per second allowed through.
+	Rate float64
+	// Burst is the maximum number of entries allowed through at once.
+	Burst int
+	// KeyField is the name of the field whose value selects the bucket.
+	KeyField string
+	// GlobalRate, when set, is the number of entries per second allowed
+	// through for all keys together, on top of the limit of each key.
+	GlobalRate float64
+	// GlobalBurst is the maximum number of entries of all keys allowed
+	// through at once. Defaults to 1.
+	GlobalBurst int
+	// Name identifies the hook to the error handler.
+	Name string
+
+	errorHandler errhandler.ErrorHandler
+	stats        stats.Recorder
+	mu           sync.Mutex
+	buckets      map[string]*tokenBucket
+	global       *tokenBucket
+	now          func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	dropped int
+	// first is the first entry dropped since the last one forwarded, and
+	// timer sends its summary when the limit lifts.
+	first entrySnapshot
+	timer *time.Timer
+}
+
+// NewRateLimitHook returns a RateLimitHook wrapping hook.
+func NewRateLimitHook(hook logrus.Hook, rate float64, burst int, keyField string) *RateLimitHook {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitHook{
+		Hook:         hook,
+		Rate:         rate,
+		Burst:        burst,
+		KeyField:     keyField,
+		Name:         "ratelimit",
+		errorHandler: errhandler.Stderr,
+		buckets:      make(map[string]*tokenBucket),
+		now:          time.Now,
+	}
+}
+
+// Levels returns the levels of the wrapped hook.
+func (hook *RateLimitHook) Levels() []logrus.Level {
+	return hook.Hook.Levels()
+}
+
+// SetErrorHandler sets the handler failures to fire a summary are reported
+// to, and passes it on to the wrapped hook.
+func (hook *RateLimitHook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	hook.errorHandler = handler
+	setErrorHandler(hook.Hook, handler)
+}
+
+// Fire forwards the entry if its bucket, and the global one, have a token
+// left and drops it otherwise. The first entry forwarded after a drop
+// carries the number of dropped entries in the dropped_count field.
+func (hook *RateLimitHook) Fire(entry *logrus.Entry) error {
+	if passThrough(hook.Hook, entry) {
+		return hook.Hook.Fire(entry)
+	}
+	key := hook.key(entry)
+
+	hook.mu.Lock()
+	now := hook.now()
+	b, ok := hook.buckets[key]
+	if !ok {
+		if len(hook.buckets) >= maxRateLimitKeys {
+			hook.prune(now)
+		}
+		b = &tokenBucket{tokens: float64(hook.Burst), last: now}
+		hook.buckets[key] = b
+	}
+	if !hook.take(b, now) {
+		b.dropped++
+		if b.dropped == 1 {
+			b.first = snapshot(entry)
+		}
+		if b.timer == nil {
+			b.timer = time.AfterFunc(hook.wait(b), func() { hook.release(key, false) })
+		}
+		hook.mu.Unlock()
+		hook.stats.Dropped()
+		return nil
+	}
+	dropped := b.reset()
+	hook.mu.Unlock()
+
+	if dropped > 0 {
+		entry = withFields(entry, logrus.Fields{FieldDroppedCount: dropped})
+	}
+	return hook.Hook.Fire(entry)
+}
+
+// Flush sends the summaries of all keys with dropped entries immediately.
+func (hook *RateLimitHook) Flush() {
+	hook.mu.Lock()
+	var keys []string
+	for k, b := range hook.buckets {
+		if b.dropped > 0 {
+			keys = append(keys, k)
+		}
+	}
+	hook.mu.Unlock()
+
+	for _, k := range keys {
+		hook.release(k, true)
+	}
+}
+
+// release sends the summary of the entries dropped for key once the limit
+// lifts, or right away when force is set. It waits again while the bucket
+// or the global one are still empty.
+func (hook *RateLimitHook) release(key string, force bool) {
+	hook.mu.Lock()
+	b, ok := hook.buckets[key]
+	if !ok || b.dropped == 0 {
+		hook.mu.Unlock()
+		return
+	}
+	if !hook.take(b, hook.now()) && !force {
+		b.timer = time.AfterFunc(hook.wait(b), func() { hook.release(key, false) })
+		hook.mu.Unlock()
+		return
+	}
+	first := b.first
+	dropped := b.reset()
+	hook.mu.Unlock()
+
+	summary := first.entry(logrus.Fields{FieldDroppedCount: dropped})
+	if err := hook.Hook.Fire(summary); err != nil && hook.errorHandler != nil {
+		hook.errorHandler.HandleError(hook.Name, summary, err)
+	}
+}
+
+// take refills b and the global bucket and takes a token from both, if they
+// both have one left.
+func (hook *RateLimitHook) take(b *tokenBucket, now time.Time) bool {
+	b.refill(now, hook.Rate, hook.Burst)
+	if b.tokens < 1 {
+		return false
+	}
+	if hook.GlobalRate > 0 {
+		if hook.global == nil {
+			hook.global = &tokenBucket{tokens: float64(hook.globalBurst()), last: now}
+		}
+		hook.global.refill(now, hook.GlobalRate, hook.globalBurst())
+		if hook.global.tokens < 1 {
+			return false
+		}
+		hook.global.tokens--
+	}
+	b.tokens--
+	return true
+}
+
+// wait returns the time until b, and the global bucket, have a token again.
+func (hook *RateLimitHook) wait(b *tokenBucket) time.Duration {
+	d := b.wait(hook.Rate)
+	if hook.global != nil {
+		if g := hook.global.wait(hook.GlobalRate); g > d {
+			d = g
+		}
+	}
+	return d
+}
+
+func (hook *RateLimitHook) globalBurst() int {
+	if hook.GlobalBurst < 1 {
+		return 1
+	}
+	return hook.GlobalBurst
+}
+
+// Stats returns the statistics of the wrapped hook, with the rate limited
+// entries counted as dropped.
+func (hook *RateLimitHook) Stats() stats.Snapshot {
+	return addDropped(hook.Hook, &hook.stats)
+}
+
+// Captures reports whether the wrapped hook sends entries of level as events.
+func (hook *RateLimitHook) Captures(level logrus.Level) bool {
+	return captures(hook.Hook, level)
+}
+
+// Health returns the health of the wrapped hook.
+func (hook *RateLimitHook) Health() stats.Health {
+	h, _ := healthOf(hook.Hook)
+	return h
+}
+
+// Dropped returns the number of entries dropped per key since the last entry
+// forwarded for that key.
+func (hook *RateLimitHook) Dropped() map[string]int {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	dropped := make(map[string]int)
+	for k, b := range hook.buckets {
+		if b.dropped > 0 {
+			dropped[k] = b.dropped
+		}
+	}
+	return dropped
+}
+
+func (hook *RateLimitHook) key(entry *logrus.Entry) string {
+	if hook.KeyField == "" {
+		return ""
+	}
+	v, ok := entry.Data[hook.KeyField]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// prune discards buckets that are full again and have nothing to report.
+func (hook *RateLimitHook) prune(now time.Time) {
+	for k, b := range hook.buckets {
+		b.refill(now, hook.Rate, hook.Burst)
+		if b.dropped == 0 && b.tokens >= float64(hook.Burst) {
+			delete(hook.buckets, k)
+		}
+	}
+}
+
+// reset stops the summary of b and returns the number of dropped entries it
+// was for.
+func (b *tokenBucket) reset() int {
+	dropped := b.dropped
+	b.dropped = 0
+	b.first = entrySnapshot{}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return dropped
+}
+
+// wait returns the time until b has a token again, at least a millisecond.
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	if rate <= 0 {
+		return time.Hour
+	}
+	d := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// withFields returns a copy of entry with fields added, leaving entry itself
+// untouched for the other hooks.
+func withFields(entry *logrus.Entry, fields logrus.Fields) *logrus.Entry {
+	e := entry.WithFields(fields)
+	e.Time = entry.Time
+	e.Level = entry.Level
+	e.Message = entry.Message
+	e.Caller = entry.Caller
+	return e
+}
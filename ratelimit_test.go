@@ -0,0 +1,126 @@
+package logrus_hooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitHookPerKey(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewRateLimitHook(rec, 1, 2, "tenant_id")
+	now := time.Now()
+	hook.now = func() time.Time { return now }
+	log := newTestLogger(hook)
+
+	for i := 0; i < 5; i++ {
+		log.WithField("tenant_id", "noisy").Error("boom")
+	}
+	log.WithField("tenant_id", "quiet").Error("boom")
+
+	assert.Len(t, rec.all(), 3)
+	assert.Equal(t, map[string]int{"noisy": 3}, hook.Dropped())
+
+	now = now.Add(time.Second)
+	log.WithField("tenant_id", "noisy").Error("boom")
+
+	entries := rec.all()
+	assert.Len(t, entries, 4)
+	assert.Equal(t, 3, entries[3].Data[FieldDroppedCount])
+	assert.Empty(t, hook.Dropped())
+}
+
+func TestRateLimitHookDoesNotMutateEntry(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewRateLimitHook(rec, 1, 1, "")
+	now := time.Now()
+	hook.now = func() time.Time { return now }
+
+	entry := logrus.NewEntry(logrus.New())
+	assert.NoError(t, hook.Fire(entry))
+	assert.NoError(t, hook.Fire(entry))
+	now = now.Add(time.Second)
+	assert.NoError(t, hook.Fire(entry))
+
+	assert.Len(t, rec.all(), 2)
+	assert.NotContains(t, entry.Data, FieldDroppedCount)
+}
+
+// capturingHook only sends events for the error level.
+type capturingHook struct {
+	recordingHook
+}
+
+func (h *capturingHook) Captures(level logrus.Level) bool {
+	return level == logrus.ErrorLevel
+}
+
+func TestRateLimitHookPassesThroughBreadcrumbs(t *testing.T) {
+	rec := &capturingHook{}
+	hook := NewRateLimitHook(rec, 1, 1, "")
+	hook.now = time.Now
+	log := newTestLogger(hook)
+
+	log.Info("breadcrumb")
+	log.Info("breadcrumb")
+	log.Error("boom")
+
+	assert.Len(t, rec.all(), 3)
+	assert.Empty(t, hook.Dropped())
+}
+
+func TestRateLimitHookSummaryWhenKeyGoesQuiet(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewRateLimitHook(rec, 20, 1, "tenant_id")
+	log := newTestLogger(hook)
+
+	log.WithFields(logrus.Fields{"tenant_id": "noisy", "n": 1}).Error("boom")
+	log.WithFields(logrus.Fields{"tenant_id": "noisy", "n": 2}).Error("boom")
+	log.WithFields(logrus.Fields{"tenant_id": "noisy", "n": 3}).Error("boom")
+	assert.Len(t, rec.all(), 1)
+
+	time.Sleep(200 * time.Millisecond)
+	entries := rec.all()
+	if assert.Len(t, entries, 2, "the summary should be sent once the limit lifts") {
+		assert.Equal(t, 2, entries[1].Data[FieldDroppedCount])
+		assert.Equal(t, 2, entries[1].Data["n"], "the summary should be built from the first dropped entry")
+		assert.Equal(t, "noisy", entries[1].Data["tenant_id"])
+	}
+	assert.Empty(t, hook.Dropped())
+}
+
+func TestRateLimitHookFlush(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewRateLimitHook(rec, 0.001, 1, "")
+	log := newTestLogger(hook)
+
+	log.Error("boom")
+	log.Error("boom")
+	hook.Flush()
+
+	entries := rec.all()
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, 1, entries[1].Data[FieldDroppedCount])
+	}
+	hook.Flush()
+	assert.Len(t, rec.all(), 2)
+}
+
+func TestRateLimitHookGlobalRate(t *testing.T) {
+	rec := &recordingHook{}
+	hook := NewRateLimitHook(rec, 1, 2, "tenant_id")
+	hook.GlobalRate = 1
+	hook.GlobalBurst = 3
+	now := time.Now()
+	hook.now = func() time.Time { return now }
+	log := newTestLogger(hook)
+
+	for _, tenant := range []string{"a", "a", "b", "b", "c"} {
+		log.WithField("tenant_id", tenant).Error("boom")
+	}
+
+	assert.Len(t, rec.all(), 3)
+	assert.Equal(t, map[string]int{"b": 1, "c": 1}, hook.Dropped())
+}
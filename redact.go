@@ -0,0 +1,561 @@
+package logrus_hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/errchain"
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RedactStrategy selects how a sensitive value is replaced.
+type RedactStrategy int
+
+const (
+	// RedactMask replaces the value with RedactedValue.
+	RedactMask RedactStrategy = iota
+	// RedactHash replaces the value with a truncated sha256 hash, so equal
+	// values can still be correlated.
+	RedactHash
+	// RedactRemove drops the field altogether.
+	RedactRemove
+)
+
+// RedactedValue replaces masked values.
+const RedactedValue = "[REDACTED]"
+
+// CardNumberPattern matches 13 to 19 digits, optionally separated by spaces
+// or dashes. Its matches are only redacted when they pass the Luhn check, so
+// that order numbers and other long IDs are kept.
+const CardNumberPattern = `\b(?:\d[ -]?){12,18}\d\b`
+
+// maxRedactDepth bounds the traversal of nested values, which also guards
+// against cyclic pointers.
+const maxRedactDepth = 10
+
+var (
+	// DefaultRedactKeys are the key name patterns used when none are
+	// configured. They are matched case-insensitively.
+	DefaultRedactKeys = []string{
+		"passw(or)?d", "secret", "token", "api_?key", "authorization", "cookie", "session",
+	}
+	// DefaultRedactValues are the value patterns used when none are
+	// configured: card numbers, JWTs and email addresses.
+	DefaultRedactValues = []string{
+		CardNumberPattern,
+		`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+		`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	}
+)
+
+// RedactConfig configures the redaction applied to the entries of a logger.
+type RedactConfig struct {
+	// Strategy is one of mask, hash or remove. Redaction is disabled when
+	// it is empty.
+	Strategy string `toml:"strategy,omitempty"`
+	// Keys are regular expressions matched against field and header names.
+	Keys []string `toml:"keys,omitempty"`
+	// Values are regular expressions matched against string values.
+	Values []string `toml:"values,omitempty"`
+}
+
+// Redactor removes sensitive data from entries before hooks see them.
+type Redactor struct {
+	Strategy RedactStrategy
+	keys     []*regexp.Regexp
+	values   []valuePattern
+}
+
+// valuePattern is a compiled value pattern; the matches of a pattern with
+// luhn set are only redacted when they pass the Luhn check.
+type valuePattern struct {
+	re   *regexp.Regexp
+	luhn bool
+}
+
+// NewRedactor compiles the key and value patterns into a Redactor. Key
+// patterns are matched case-insensitively.
+func NewRedactor(strategy RedactStrategy, keys, values []string) (*Redactor, error) {
+	r := &Redactor{Strategy: strategy}
+	for _, k := range keys {
+		re, err := regexp.Compile("(?i)" + k)
+		if err != nil {
+			return nil, err
+		}
+		r.keys = append(r.keys, re)
+	}
+	for _, v := range values {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		r.values = append(r.values, valuePattern{re: re, luhn: v == CardNumberPattern})
+	}
+	return r, nil
+}
+
+// Wrap returns a hook that fires hook with a redacted copy of every entry.
+func (r *Redactor) Wrap(hook logrus.Hook) logrus.Hook {
+	return &redactHook{hook: hook, redactor: r}
+}
+
+// RedactEntry returns a copy of entry with its message and data redacted.
+// The fields extracted from the context of entry, see ctxfields, are merged
+// into the data first, so that they are redacted as well. The original entry
+// is left untouched.
+func (r *Redactor) RedactEntry(entry *logrus.Entry) *logrus.Entry {
+	e := *entry
+	e.Message = r.redactString(entry.Message)
+	e.Data = r.Redact(ctxfields.Merge(entry))
+	if e.Context != nil {
+		e.Context = ctxfields.Extracted(e.Context)
+	}
+	return &e
+}
+
+// Redact returns a redacted copy of data. Maps, slices, structs and
+// *http.Request values, bare or in an httpreq.Value, are traversed.
+func (r *Redactor) Redact(data logrus.Fields) logrus.Fields {
+	result := make(logrus.Fields, len(data))
+	for k, v := range data {
+		if r.matchKey(k) {
+			if r.Strategy != RedactRemove {
+				result[k] = r.replace(v)
+			}
+			continue
+		}
+		if v, changed := r.redactValue(reflect.ValueOf(v), 0); changed {
+			result[k] = v
+		} else {
+			result[k] = data[k]
+		}
+	}
+	return result
+}
+
+func (r *Redactor) matchKey(key string) bool {
+	for _, re := range r.keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// replace returns the replacement for a value whose key is sensitive.
+func (r *Redactor) replace(v interface{}) interface{} {
+	if r.Strategy == RedactHash {
+		sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return RedactedValue
+}
+
+func (r *Redactor) redactString(s string) string {
+	for _, p := range r.values {
+		s = p.re.ReplaceAllStringFunc(s, func(match string) string {
+			if p.luhn && !luhnValid(match) {
+				return match
+			}
+			switch r.Strategy {
+			case RedactRemove:
+				return ""
+			case RedactHash:
+				return r.replace(match).(string)
+			default:
+				return RedactedValue
+			}
+		})
+	}
+	return s
+}
+
+// luhnValid reports whether the digits of s pass the Luhn check of card
+// numbers; other characters are ignored.
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactValue returns a redacted version of v and whether anything changed.
+// Values that need no redaction are returned as is. Redacted containers keep
+// their type when the replacements fit it, and become generic maps and
+// slices otherwise.
+func (r *Redactor) redactValue(v reflect.Value, depth int) (interface{}, bool) {
+	if !v.IsValid() || !v.CanInterface() || depth > maxRedactDepth {
+		return nil, false
+	}
+	if req, ok := v.Interface().(*http.Request); ok {
+		return r.redactRequest(req)
+	}
+	if hv, ok := v.Interface().(httpreq.Value); ok {
+		if req, changed := r.redactRequest(hv.Request); changed {
+			return httpreq.Value{Request: req.(*http.Request)}, true
+		}
+		return nil, false
+	}
+	if err, ok := v.Interface().(error); ok {
+		return r.redactError(err, depth)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		s := r.redactString(v.String())
+		return s, s != v.String()
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		return r.redactValue(v.Elem(), depth+1)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, false
+		}
+		nv, changed := r.redactValue(v.Elem(), depth+1)
+		if changed && nv != nil && reflect.TypeOf(nv) == v.Elem().Type() {
+			p := reflect.New(v.Elem().Type())
+			p.Elem().Set(reflect.ValueOf(nv))
+			return p.Interface(), true
+		}
+		return nv, changed
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		generic := make(map[string]interface{}, v.Len())
+		typed := reflect.MakeMapWithSize(v.Type(), v.Len())
+		changed, keepType := false, true
+		for _, k := range v.MapKeys() {
+			if r.matchKey(k.String()) {
+				changed = true
+				if r.Strategy == RedactRemove {
+					continue
+				}
+				generic[k.String()] = r.replace(v.MapIndex(k).Interface())
+			} else {
+				nv, c := r.redactValue(v.MapIndex(k), depth+1)
+				if !c {
+					nv = v.MapIndex(k).Interface()
+				}
+				generic[k.String()] = nv
+				changed = changed || c
+			}
+			if rv, ok := assignable(generic[k.String()], v.Type().Elem()); ok && keepType {
+				typed.SetMapIndex(k, rv)
+			} else {
+				keepType = false
+			}
+		}
+		if keepType {
+			return typed.Interface(), changed
+		}
+		return generic, changed
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, false
+		}
+		generic := make([]interface{}, v.Len())
+		changed := false
+		for i := 0; i < v.Len(); i++ {
+			nv, c := r.redactValue(v.Index(i), depth+1)
+			if !c {
+				nv = v.Index(i).Interface()
+			}
+			generic[i] = nv
+			changed = changed || c
+		}
+		if v.Kind() == reflect.Slice {
+			typed := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+			for i, nv := range generic {
+				rv, ok := assignable(nv, v.Type().Elem())
+				if !ok {
+					return generic, changed
+				}
+				typed.Index(i).Set(rv)
+			}
+			return typed.Interface(), changed
+		}
+		return generic, changed
+	case reflect.Struct:
+		t := v.Type()
+		generic := make(map[string]interface{}, t.NumField())
+		typed := reflect.New(t).Elem()
+		typed.Set(v)
+		changed, keepType := false, true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			var nv interface{}
+			c := false
+			if r.matchKey(f.Name) {
+				c = true
+				if r.Strategy != RedactRemove {
+					nv = r.replace(v.Field(i).Interface())
+				}
+			} else if nv, c = r.redactValue(v.Field(i), depth+1); !c {
+				nv = v.Field(i).Interface()
+			}
+			generic[f.Name] = nv
+			if !c {
+				continue
+			}
+			changed = true
+			if rv, ok := assignable(nv, f.Type); ok {
+				typed.Field(i).Set(rv)
+			} else {
+				keepType = false
+			}
+		}
+		if keepType {
+			return typed.Interface(), changed
+		}
+		return generic, changed
+	}
+	return nil, false
+}
+
+// redactError returns a copy of err with the messages of err and of the
+// errors it wraps redacted, and whether anything changed. The copy keeps the
+// chain, the type names, see errchain.TypeName, and the pkg/errors stack
+// traces of the original.
+func (r *Redactor) redactError(err error, depth int) (interface{}, bool) {
+	if depth > maxRedactDepth {
+		return nil, false
+	}
+	msg := r.redactString(err.Error())
+	changed := msg != err.Error()
+	next := errchain.Unwrap(err)
+	wrapped := make([]error, len(next))
+	for i, n := range next {
+		wrapped[i] = n
+		if n == nil {
+			continue
+		}
+		if rn, c := r.redactError(n, depth+1); c {
+			wrapped[i] = rn.(error)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	re := &redactedError{msg: msg, orig: err, next: wrapped}
+	_, hasStack := err.(stackTracer)
+	switch {
+	case len(wrapped) == 1 && hasStack:
+		return &redactedStackWrapper{&redactedWrapper{re}}, true
+	case len(wrapped) == 1:
+		return &redactedWrapper{re}, true
+	case hasStack:
+		return &redactedStackError{re}, true
+	}
+	return re, true
+}
+
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+// redactedError is a redacted copy of an error.
+type redactedError struct {
+	msg  string
+	orig error
+	next []error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() []error {
+	return e.next
+}
+
+// TypeName returns the type of the original error.
+func (e *redactedError) TypeName() string {
+	return errchain.TypeName(e.orig)
+}
+
+// redactedWrapper is a redacted copy of an error wrapping a single error,
+// which it also exposes as its pkg/errors cause.
+type redactedWrapper struct {
+	*redactedError
+}
+
+func (e *redactedWrapper) Unwrap() error {
+	return e.next[0]
+}
+
+func (e *redactedWrapper) Cause() error {
+	return e.next[0]
+}
+
+// redactedStackError and redactedStackWrapper are the redacted copies of
+// errors carrying a pkg/errors stack trace.
+type redactedStackError struct {
+	*redactedError
+}
+
+func (e *redactedStackError) StackTrace() pkgerrors.StackTrace {
+	return e.orig.(stackTracer).StackTrace()
+}
+
+type redactedStackWrapper struct {
+	*redactedWrapper
+}
+
+func (e *redactedStackWrapper) StackTrace() pkgerrors.StackTrace {
+	return e.orig.(stackTracer).StackTrace()
+}
+
+// assignable returns v as a reflect.Value if it can be stored in a value of
+// type t. nil converts to the zero value of t.
+func assignable(v interface{}, t reflect.Type) (reflect.Value, bool) {
+	if v == nil {
+		return reflect.Zero(t), true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(t) {
+		return rv, true
+	}
+	return reflect.Value{}, false
+}
+
+// redactRequest returns a shallow copy of req with sensitive headers and
+// query parameters redacted.
+func (r *Redactor) redactRequest(req *http.Request) (interface{}, bool) {
+	if req == nil {
+		return nil, false
+	}
+	changed := false
+	header := make(http.Header, len(req.Header))
+	for k, vs := range req.Header {
+		if r.matchKey(k) {
+			changed = true
+			if r.Strategy != RedactRemove {
+				header[k] = []string{r.replace(strings.Join(vs, ",")).(string)}
+			}
+			continue
+		}
+		header[k] = vs
+	}
+
+	var u = req.URL
+	if req.URL != nil && req.URL.RawQuery != "" {
+		q := req.URL.Query()
+		for k, vs := range q {
+			if r.matchKey(k) {
+				changed = true
+				if r.Strategy == RedactRemove {
+					q.Del(k)
+				} else {
+					q.Set(k, r.replace(strings.Join(vs, ",")).(string))
+				}
+			}
+		}
+		if changed {
+			cp := *req.URL
+			cp.RawQuery = q.Encode()
+			u = &cp
+		}
+	}
+
+	if !changed {
+		return nil, false
+	}
+	cp := req.WithContext(req.Context())
+	cp.Header = header
+	cp.URL = u
+	return cp, true
+}
+
+// getRedactStrategy converts the strategy names used in the configuration.
+func getRedactStrategy(s string) RedactStrategy {
+	switch s {
+	case "mask":
+		return RedactMask
+	case "hash":
+		return RedactHash
+	case "remove":
+		return RedactRemove
+	default:
+		panic("Unable to determine redact strategy from string: " + s)
+	}
+}
+
+// genRedactor builds the Redactor for a logger, or returns nil when
+// redaction is not configured.
+func genRedactor(c RedactConfig) *Redactor {
+	if c.Strategy == "" {
+		return nil
+	}
+	keys, values := c.Keys, c.Values
+	if len(keys) == 0 {
+		keys = DefaultRedactKeys
+	}
+	if len(values) == 0 {
+		values = DefaultRedactValues
+	}
+	r, err := NewRedactor(getRedactStrategy(c.Strategy), keys, values)
+	if err != nil {
+		panic("Unable to compile redact patterns: " + err.Error())
+	}
+	return r
+}
+
+type redactHook struct {
+	hook     logrus.Hook
+	redactor *Redactor
+}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	return h.hook.Fire(h.redactor.RedactEntry(entry))
+}
+
+func (h *redactHook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	setErrorHandler(h.hook, handler)
+}
+
+func (h *redactHook) Stats() stats.Snapshot {
+	s, _ := statsOf(h.hook)
+	return s
+}
+
+func (h *redactHook) Captures(level logrus.Level) bool {
+	return captures(h.hook, level)
+}
+
+func (h *redactHook) Health() stats.Health {
+	health, _ := healthOf(h.hook)
+	return health
+}
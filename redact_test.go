@@ -0,0 +1,205 @@
+package logrus_hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/errchain"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRequestInfo struct {
+	URL     string
+	Cookies string
+	Headers map[string]string
+}
+
+func newTestRedactor(t *testing.T, strategy RedactStrategy) *Redactor {
+	r, err := NewRedactor(strategy, DefaultRedactKeys, DefaultRedactValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRedactFields(t *testing.T) {
+	r := newTestRedactor(t, RedactMask)
+
+	data := logrus.Fields{
+		"password": "hunter2",
+		"user":     "jane",
+		"nested": map[string]interface{}{
+			"api_key": "abc",
+			"note":    "card 4111 1111 1111 1111",
+			"order":   "order 4111 1111 1111 1112",
+		},
+		"info": &testRequestInfo{
+			URL:     "/login",
+			Cookies: "session=abc",
+			Headers: map[string]string{"Authorization": "Bearer abc", "Accept": "*/*"},
+		},
+	}
+	redacted := r.Redact(data)
+
+	assert.Equal(t, RedactedValue, redacted["password"])
+	assert.Equal(t, "jane", redacted["user"])
+	nested := redacted["nested"].(map[string]interface{})
+	assert.Equal(t, RedactedValue, nested["api_key"])
+	assert.Equal(t, "card "+RedactedValue, nested["note"])
+	assert.Equal(t, "order 4111 1111 1111 1112", nested["order"], "numbers failing the Luhn check should be kept")
+
+	info, ok := redacted["info"].(*testRequestInfo)
+	if assert.True(t, ok, "struct type should be kept") {
+		assert.Equal(t, "/login", info.URL)
+		assert.Equal(t, RedactedValue, info.Cookies)
+		assert.Equal(t, RedactedValue, info.Headers["Authorization"])
+		assert.Equal(t, "*/*", info.Headers["Accept"])
+	}
+
+	// the original data must be left untouched
+	assert.Equal(t, "hunter2", data["password"])
+	assert.Equal(t, "Bearer abc", data["info"].(*testRequestInfo).Headers["Authorization"])
+}
+
+func TestRedactStrategies(t *testing.T) {
+	data := logrus.Fields{"token": "abc", "email": "jane@example.com"}
+
+	hashed := newTestRedactor(t, RedactHash).Redact(data)
+	assert.True(t, strings.HasPrefix(hashed["token"].(string), "sha256:"))
+	assert.Equal(t, hashed["token"], newTestRedactor(t, RedactHash).Redact(data)["token"])
+	assert.NotContains(t, hashed["email"], "jane@example.com")
+
+	removed := newTestRedactor(t, RedactRemove).Redact(data)
+	assert.NotContains(t, removed, "token")
+	assert.Equal(t, "", removed["email"])
+}
+
+func TestRedactHTTPRequest(t *testing.T) {
+	r := newTestRedactor(t, RedactMask)
+	req, _ := http.NewRequest("GET", "http://example.com/?token=abc&page=2", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("Accept", "*/*")
+
+	redacted := r.Redact(logrus.Fields{"http_request": req})["http_request"].(*http.Request)
+
+	assert.Equal(t, RedactedValue, redacted.Header.Get("Authorization"))
+	assert.Equal(t, "*/*", redacted.Header.Get("Accept"))
+	assert.Equal(t, "2", redacted.URL.Query().Get("page"))
+	assert.Equal(t, RedactedValue, redacted.URL.Query().Get("token"))
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+	assert.Equal(t, "abc", req.URL.Query().Get("token"))
+}
+
+func TestRedactHTTPRequestValue(t *testing.T) {
+	r := newTestRedactor(t, RedactMask)
+	req, _ := http.NewRequest("GET", "http://example.com/?page=2", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+
+	redacted, ok := r.Redact(logrus.Fields{"http_request": httpreq.Value{Request: req}})["http_request"].(httpreq.Value)
+	if assert.True(t, ok, "the request should stay wrapped") {
+		assert.Equal(t, RedactedValue, redacted.Request.Header.Get("Authorization"))
+	}
+	assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+}
+
+func TestRedactorWrap(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(newTestRedactor(t, RedactMask).Wrap(rec))
+
+	log.WithField("password", "hunter2").Error("login failed for jane@example.com")
+
+	entries := rec.all()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, RedactedValue, entries[0].Data["password"])
+	assert.Equal(t, "login failed for "+RedactedValue, entries[0].Message)
+}
+
+type redactSecretKey struct{}
+
+func TestRedactorWrapContextFields(t *testing.T) {
+	ctxfields.Register(func(ctx context.Context) logrus.Fields {
+		if secret, ok := ctx.Value(redactSecretKey{}).(string); ok {
+			return logrus.Fields{"api_key": secret, "tenant": "acme"}
+		}
+		return nil
+	})
+	ctx := context.WithValue(context.Background(), redactSecretKey{}, "abc")
+
+	for _, strategy := range []RedactStrategy{RedactMask, RedactRemove} {
+		rec := &recordingHook{}
+		log := newTestLogger(newTestRedactor(t, strategy).Wrap(rec))
+		log.WithContext(ctx).Error("request failed")
+
+		entries := rec.all()
+		assert.Len(t, entries, 1)
+		data := ctxfields.Merge(entries[0])
+		assert.NotEqual(t, "abc", data["api_key"], "the fields extracted from the context should be redacted (strategy %d)", strategy)
+		assert.Equal(t, "acme", data["tenant"])
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	r := newTestRedactor(t, RedactMask)
+	root := &customErr{"no account for jane@example.com"}
+	err := fmt.Errorf("login: %w", pkgerrors.WithStack(root))
+
+	data := r.Redact(logrus.Fields{logrus.ErrorKey: err})
+	redacted, ok := data[logrus.ErrorKey].(error)
+	if !assert.True(t, ok) {
+		return
+	}
+	assert.Equal(t, "login: no account for "+RedactedValue, redacted.Error())
+	links := errchain.Chain(redacted)
+	if assert.Len(t, links, 2) {
+		assert.Equal(t, "*fmt.wrapError", errchain.TypeName(links[0].Err))
+		assert.Equal(t, "no account for "+RedactedValue, links[1].Err.Error())
+		assert.Equal(t, "*logrus_hooks.customErr", errchain.TypeName(links[1].Err))
+		_, hasStack := links[1].Layers[0].(stackTracer)
+		assert.True(t, hasStack, "the stack trace of the original should be kept")
+	}
+	_, hasStack := redacted.(stackTracer)
+	assert.False(t, hasStack)
+	assert.Equal(t, "*logrus_hooks.customErr", errchain.TypeName(pkgerrors.Cause(redacted)))
+	assert.Equal(t, "login: no account for jane@example.com", err.Error(), "the original should be left untouched")
+
+	plain := errors.New("boom")
+	assert.Equal(t, plain, r.Redact(logrus.Fields{logrus.ErrorKey: plain})[logrus.ErrorKey])
+}
+
+// customErr is an error type of this package.
+type customErr struct {
+	msg string
+}
+
+func (e *customErr) Error() string {
+	return e.msg
+}
+
+func TestGenRedactor(t *testing.T) {
+	assert.Nil(t, genRedactor(RedactConfig{}))
+	assert.NotNil(t, genRedactor(RedactConfig{Strategy: "hash"}))
+	assert.Panics(t, func() { genRedactor(RedactConfig{Strategy: "shred"}) })
+	assert.Panics(t, func() { genRedactor(RedactConfig{Strategy: "mask", Keys: []string{"("}}) })
+}
+
+func TestLuhnValid(t *testing.T) {
+	for s, expected := range map[string]bool{
+		"4111111111111111":    true,
+		"4111-1111-1111-1111": true,
+		"5500 0000 0000 0004": true,
+		"378282246310005":     true,
+		"4111111111111112":    false,
+		"1234567812345678":    false,
+		"20240101123456":      false,
+	} {
+		assert.Equal(t, expected, luhnValid(s), s)
+	}
+}
@@ -0,0 +1,128 @@
+package logrus_hooks
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/CIP-NL/logrus-hooks/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// FieldSampleRate is added to every entry forwarded by a SampleHook so that
+// dashboards can extrapolate the real number of events.
+const FieldSampleRate = "sample_rate"
+
+// SampleHook wraps a hook and forwards only a fraction of the entries of each
+// level. When KeyField is set the decision is derived from the value of that
+// field, so all entries sharing it (e.g. one trace_id) are kept or dropped
+// together.
+type SampleHook struct {
+	// Hook is the wrapped hook.
+	Hook logrus.Hook
+	// Rates maps a level to the fraction of its entries to keep, between 0
+	// and 1. Levels that are not present are always kept.
+	Rates map[logrus.Level]float64
+	// KeyField is the name of the field to sample deterministically on. The
+	// trace_id key also matches the trace of entry.Context, see tracing.IDs.
+	KeyField string
+
+	stats stats.Recorder
+	mu    sync.Mutex
+	rnd   *rand.Rand
+}
+
+// NewSampleHook returns a SampleHook wrapping hook.
+func NewSampleHook(hook logrus.Hook, rates map[logrus.Level]float64, keyField string) *SampleHook {
+	return &SampleHook{
+		Hook:     hook,
+		Rates:    rates,
+		KeyField: keyField,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Levels returns the levels of the wrapped hook.
+func (hook *SampleHook) Levels() []logrus.Level {
+	return hook.Hook.Levels()
+}
+
+// SetErrorHandler passes handler on to the wrapped hook.
+func (hook *SampleHook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	setErrorHandler(hook.Hook, handler)
+}
+
+// Fire forwards the entry, tagged with its sample rate, if it is sampled.
+func (hook *SampleHook) Fire(entry *logrus.Entry) error {
+	if passThrough(hook.Hook, entry) {
+		return hook.Hook.Fire(entry)
+	}
+	rate, ok := hook.Rates[entry.Level]
+	if !ok || rate >= 1 {
+		return hook.Hook.Fire(entry)
+	}
+	if rate <= 0 || hook.sample(entry) >= rate {
+		hook.stats.Dropped()
+		return nil
+	}
+	return hook.Hook.Fire(withFields(entry, logrus.Fields{FieldSampleRate: rate}))
+}
+
+// Stats returns the statistics of the wrapped hook, with the entries that
+// were not sampled counted as dropped.
+func (hook *SampleHook) Stats() stats.Snapshot {
+	return addDropped(hook.Hook, &hook.stats)
+}
+
+// Captures reports whether the wrapped hook sends entries of level as events.
+func (hook *SampleHook) Captures(level logrus.Level) bool {
+	return captures(hook.Hook, level)
+}
+
+// Health returns the health of the wrapped hook.
+func (hook *SampleHook) Health() stats.Health {
+	h, _ := healthOf(hook.Hook)
+	return h
+}
+
+// sample returns a number in [0, 1) for the entry.
+func (hook *SampleHook) sample(entry *logrus.Entry) float64 {
+	if hook.KeyField != "" {
+		if v, ok := entry.Data[hook.KeyField]; ok {
+			return hashFraction(fmt.Sprint(v))
+		}
+		if hook.KeyField == tracing.FieldTraceID {
+			if traceID, _, ok := tracing.IDs(entry); ok {
+				return hashFraction(traceID)
+			}
+		}
+	}
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	return hook.rnd.Float64()
+}
+
+// hashFraction maps s uniformly onto [0, 1).
+func hashFraction(s string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return float64(h.Sum64()>>11) / float64(uint64(1)<<53)
+}
+
+// getSampleRates converts the level aliases used in the configuration to
+// logrus levels.
+func getSampleRates(h Hook) map[logrus.Level]float64 {
+	rates := make(map[logrus.Level]float64, len(h.SampleRates))
+	for lvl, rate := range h.SampleRates {
+		if math.IsNaN(rate) || rate < 0 || rate > 1 {
+			panic("Sample rate must be between 0 and 1 for hook: " + h.Name)
+		}
+		rates[getLevelFromString(lvl)] = rate
+	}
+	return rates
+}
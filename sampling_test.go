@@ -0,0 +1,90 @@
+package logrus_hooks
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CIP-NL/logrus-hooks/tracing"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSampleHookRates(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(NewSampleHook(rec, map[logrus.Level]float64{
+		logrus.WarnLevel:  0,
+		logrus.ErrorLevel: 0.5,
+	}, ""))
+
+	for i := 0; i < 1000; i++ {
+		log.Warn("dropped")
+		log.Error("sampled")
+	}
+	entries := rec.all()
+	assert.InDelta(t, 500, len(entries), 100)
+	for _, e := range entries {
+		assert.Equal(t, logrus.ErrorLevel, e.Level)
+		assert.Equal(t, 0.5, e.Data[FieldSampleRate])
+	}
+}
+
+func TestSampleHookDeterministicOnField(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(NewSampleHook(rec, map[logrus.Level]float64{
+		logrus.ErrorLevel: 0.5,
+	}, "trace_id"))
+
+	for i := 0; i < 100; i++ {
+		traceID := fmt.Sprintf("trace-%d", i)
+		for j := 0; j < 3; j++ {
+			log.WithField("trace_id", traceID).Error("boom")
+		}
+	}
+
+	counts := make(map[interface{}]int)
+	for _, e := range rec.all() {
+		counts[e.Data["trace_id"]]++
+	}
+	assert.NotEmpty(t, counts)
+	for traceID, n := range counts {
+		assert.Equal(t, 3, n, "trace %v was partially sampled", traceID)
+	}
+}
+
+func TestSampleHookDeterministicOnTrace(t *testing.T) {
+	rec := &recordingHook{}
+	log := newTestLogger(NewSampleHook(rec, map[logrus.Level]float64{
+		logrus.ErrorLevel: 0.5,
+	}, tracing.FieldTraceID))
+
+	for i := 0; i < 100; i++ {
+		var tid trace.TraceID
+		tid[0], tid[15] = byte(i), 1
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: trace.SpanID{1}}))
+		for j := 0; j < 3; j++ {
+			log.WithContext(ctx).Error("boom")
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, e := range rec.all() {
+		traceID, _, _ := tracing.IDs(e)
+		counts[traceID]++
+	}
+	assert.NotEmpty(t, counts)
+	assert.True(t, len(counts) < 100, "some traces should have been dropped")
+	for traceID, n := range counts {
+		assert.Equal(t, 3, n, "trace %v was partially sampled", traceID)
+	}
+}
+
+func TestGetSampleRates(t *testing.T) {
+	rates := getSampleRates(Hook{Name: "sentry", SampleRates: map[string]float64{"WARN": 0.01}})
+	assert.Equal(t, map[logrus.Level]float64{logrus.WarnLevel: 0.01}, rates)
+
+	assert.Panics(t, func() {
+		getSampleRates(Hook{Name: "sentry", SampleRates: map[string]float64{"WARN": 2}})
+	})
+}
@@ -1,11 +1,16 @@
 package sentry
 
 import (
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/CIP-NL/logrus-hooks/errhandler"
 	"github.com/sirupsen/logrus"
 )
 
@@ -68,3 +73,123 @@ func TestParallelLogging(t *testing.T) {
 		}
 	})
 }
+
+func TestAsyncErrorHandler(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+	fragments := strings.SplitN(s.URL, "://", 2)
+	dsn := fmt.Sprintf("%s://public:secret@%s/sentry/project-id", fragments[0], fragments[1])
+
+	logger := getTestLogger()
+	hook, err := NewAsyncHook(dsn, []logrus.Level{
+		logrus.ErrorLevel,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	counter := &errhandler.Counter{}
+	hook.SetErrorHandler(counter)
+	logger.Hooks.Add(hook)
+
+	logger.Error(message)
+	hook.Flush()
+
+	if n := counter.Count("sentry"); n != 1 {
+		t.Errorf("error handler should have been called once, was called %d times", n)
+	}
+	if s := hook.Stats(); s.Failed != 1 || s.Sent != 0 || s.InFlight != 0 || s.FireLatency.Count != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+// blockingTransport signals every event on started and accepts it once
+// release is closed.
+type blockingTransport struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingTransport) Send(url, authHeader string, event *Event) error {
+	t.started <- struct{}{}
+	<-t.release
+	return nil
+}
+
+func TestAsyncQueueStats(t *testing.T) {
+	transport := &blockingTransport{started: make(chan struct{}, 10), release: make(chan struct{})}
+	client, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = transport
+	hook, err := NewAsyncWithClientHook(client, []logrus.Level{logrus.ErrorLevel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := getTestLogger()
+	logger.Hooks.Add(hook)
+
+	logger.Error(message)
+	select {
+	case <-transport.started:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out; the event was not sent")
+	}
+	logger.Error(message)
+
+	if s := hook.Stats(); s.QueueDepth != 1 || s.InFlight != 1 {
+		t.Errorf("expected 1 queued and 1 in flight event, got %d and %d", s.QueueDepth, s.InFlight)
+	}
+	if err := hook.FlushTimeout(10 * time.Millisecond); err != ErrFlushTimeout {
+		t.Errorf("FlushTimeout should time out while an event is being sent, got %v", err)
+	}
+
+	close(transport.release)
+	if err := hook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s := hook.Stats(); s.Sent != 2 || s.QueueDepth != 0 || s.InFlight != 0 {
+		t.Errorf("expected 2 sent events and an empty queue, got %+v", s)
+	}
+}
+
+func TestAsyncRetryAfterRateLimit(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			rw.Header().Set("Retry-After", "0.05")
+			rw.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer s.Close()
+	fragments := strings.SplitN(s.URL, "://", 2)
+	dsn := fmt.Sprintf("%s://public:secret@%s/sentry/project-id", fragments[0], fragments[1])
+
+	logger := getTestLogger()
+	hook, err := NewAsyncHook(dsn, []logrus.Level{
+		logrus.ErrorLevel,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	counter := &errhandler.Counter{}
+	hook.SetErrorHandler(counter)
+	logger.Hooks.Add(hook)
+
+	logger.Error(message)
+	if err := hook.FlushTimeout(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("the rejected event should have been sent again once, server was called %d times", n)
+	}
+	if n := counter.Count("sentry"); n != 0 {
+		t.Errorf("a retried event should not reach the error handler, %d did", n)
+	}
+	if s := hook.Stats(); s.Retried != 1 || s.Sent != 1 || s.Failed != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
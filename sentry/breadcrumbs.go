@@ -0,0 +1,133 @@
+package sentry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxBreadcrumbTrails bounds the number of loggers or requests breadcrumbs
+// are kept for. The oldest trail is discarded once it is reached.
+const maxBreadcrumbTrails = 1000
+
+// Breadcrumb is an entry logged before an event, sent along with it.
+type Breadcrumb struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Category  string                 `json:"category,omitempty"`
+	Level     Severity               `json:"level,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Breadcrumbs are the breadcrumbs of an event.
+type Breadcrumbs struct {
+	Values []*Breadcrumb `json:"values"`
+}
+
+// breadcrumbStore keeps a bounded ring of breadcrumbs per trail.
+type breadcrumbStore struct {
+	size       int
+	contextKey interface{}
+
+	mu     sync.Mutex
+	trails map[interface{}][]*Breadcrumb
+	order  []interface{}
+}
+
+func newBreadcrumbStore(size int, contextKey interface{}) *breadcrumbStore {
+	return &breadcrumbStore{
+		size:       size,
+		contextKey: contextKey,
+		trails:     make(map[interface{}][]*Breadcrumb),
+	}
+}
+
+// trail returns the key of the trail entry belongs to: the value stored
+// under the context key when there is one, and the logger otherwise.
+func (s *breadcrumbStore) trail(entry *logrus.Entry) interface{} {
+	if s.contextKey != nil && entry.Context != nil {
+		if v := entry.Context.Value(s.contextKey); v != nil {
+			return v
+		}
+	}
+	return entry.Logger
+}
+
+func (s *breadcrumbStore) add(entry *logrus.Entry) {
+	crumb := &Breadcrumb{
+		Timestamp: entry.Time,
+		Level:     severityMap[entry.Level],
+		Message:   entry.Message,
+	}
+	for k, v := range entry.Data {
+		if k == fieldLogger {
+			crumb.Category, _ = v.(string)
+			continue
+		}
+		if crumb.Data == nil {
+			crumb.Data = make(map[string]interface{}, len(entry.Data))
+		}
+		crumb.Data[k] = formatData(v)
+	}
+
+	key := s.trail(entry)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trail, ok := s.trails[key]
+	if !ok {
+		if len(s.order) >= maxBreadcrumbTrails {
+			delete(s.trails, s.order[0])
+			s.order = s.order[1:]
+		}
+		s.order = append(s.order, key)
+	}
+	trail = append(trail, crumb)
+	if len(trail) > s.size {
+		trail = trail[len(trail)-s.size:]
+	}
+	s.trails[key] = trail
+}
+
+// take returns and forgets the breadcrumbs of the trail entry belongs to.
+func (s *breadcrumbStore) take(entry *logrus.Entry) []*Breadcrumb {
+	key := s.trail(entry)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trail, ok := s.trails[key]
+	if !ok {
+		return nil
+	}
+	delete(s.trails, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return trail
+}
+
+// EnableBreadcrumbs makes the hook keep up to size entries below its levels
+// and attach them as breadcrumbs to the next event it captures. Breadcrumbs
+// are kept per logger, or per value stored under contextKey in the entry's
+// context when contextKey is not nil.
+//
+// The hook fires for all levels once breadcrumbs are enabled, so it has to be
+// called before the hook is added to a logger.
+func (hook *Hook) EnableBreadcrumbs(size int, contextKey interface{}) {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	hook.breadcrumbs = newBreadcrumbStore(size, contextKey)
+}
+
+// Captures reports whether entries of level are sent as events rather than
+// only kept as breadcrumbs.
+func (hook *Hook) Captures(level logrus.Level) bool {
+	for _, l := range hook.levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
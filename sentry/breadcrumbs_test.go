@@ -0,0 +1,78 @@
+package sentry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+type requestIDKey struct{}
+
+func TestBreadcrumbs(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		logger.SetLevel(logrus.DebugLevel)
+
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.EnableBreadcrumbs(2, nil)
+		logger.Hooks.Add(hook)
+
+		logger.Debug("dropped from the ring")
+		logger.WithField("logger", "db").Info("connecting")
+		logger.WithField("host", "db1").Warn("retrying")
+		logger.Error(message)
+
+		packet := <-pch
+		crumbs := packet.Breadcrumbs.Values
+		if len(crumbs) != 2 {
+			t.Fatalf("expected 2 breadcrumbs, got %d", len(crumbs))
+		}
+		if crumbs[0].Message != "connecting" || crumbs[0].Category != "db" || crumbs[0].Level != "info" {
+			t.Errorf("unexpected first breadcrumb: %+v", crumbs[0])
+		}
+		if crumbs[1].Message != "retrying" || crumbs[1].Data["host"] != "db1" || crumbs[1].Level != "warning" {
+			t.Errorf("unexpected second breadcrumb: %+v", crumbs[1])
+		}
+
+		// breadcrumbs are only attached to the next event
+		logger.Error(message)
+		packet = <-pch
+		if len(packet.Breadcrumbs.Values) != 0 {
+			t.Errorf("expected no breadcrumbs, got %d", len(packet.Breadcrumbs.Values))
+		}
+	})
+}
+
+func TestBreadcrumbsPerRequest(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.EnableBreadcrumbs(10, requestIDKey{})
+		logger.Hooks.Add(hook)
+
+		reqA := logger.WithContext(context.WithValue(context.Background(), requestIDKey{}, "a"))
+		reqB := logger.WithContext(context.WithValue(context.Background(), requestIDKey{}, "b"))
+
+		reqA.Info("request a")
+		reqB.Info("request b")
+		reqA.Error(message)
+
+		packet := <-pch
+		crumbs := packet.Breadcrumbs.Values
+		if len(crumbs) != 1 || crumbs[0].Message != "request a" {
+			t.Errorf("expected only the breadcrumb of request a, got %+v", crumbs)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package sentry
+
+import (
+	"strings"
+
+	"github.com/CIP-NL/logrus-hooks/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// Contexts are the contexts of an event, keyed by context name, e.g.
+// "trace".
+type Contexts map[string]interface{}
+
+// TraceContext is the context linking an event to its trace.
+type TraceContext struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id,omitempty"`
+	Type    string `json:"type"`
+}
+
+// getTrace returns the trace context of entry, see tracing.IDs. The
+// trace_id and span_id fields are omitted from the extra data.
+func (d *dataField) getTrace(entry *logrus.Entry) (*TraceContext, bool) {
+	traceID, spanID, ok := tracing.IDs(entry)
+	if !ok {
+		return nil, false
+	}
+	d.omitList[tracing.FieldTraceID] = struct{}{}
+	d.omitList[tracing.FieldSpanID] = struct{}{}
+	return &TraceContext{TraceID: traceID, SpanID: spanID, Type: "trace"}, true
+}
+
+// ContextValue is a field value that is sent in a context of the event
+// rather than as extra data. The context is named by the part of the field
+// key before the first dot and the value keyed by the rest, e.g. the field
+// "grpc.peer" is sent as the "peer" of the "grpc" context. Fields without a
+// dot in their key, or naming a context the hook sets itself such as
+// "trace", stay in the extra data. Other hooks and formatters see a plain
+// string.
+type ContextValue string
+
+// DefaultContextPrefixes are the context prefixes of new hooks, see
+// Hook.AddContextPrefix: the fields of the grpclogger interceptors.
+var DefaultContextPrefixes = []string{"grpc."}
+
+// getContexts returns the contexts made up by the fields holding a
+// ContextValue and the fields starting with one of prefixes, except those
+// naming a context in existing or already sent otherwise, and omits those
+// fields from the extra data.
+func (d *dataField) getContexts(existing Contexts, prefixes []string) map[string]map[string]string {
+	var contexts map[string]map[string]string
+	for k, v := range d.data {
+		if d.isOmit(k) {
+			continue
+		}
+		if _, ok := v.(ContextValue); !ok && !hasAnyPrefix(k, prefixes) {
+			continue
+		}
+		value, ok := stringValue(v)
+		if !ok {
+			continue
+		}
+		i := strings.Index(k, ".")
+		if i <= 0 || i == len(k)-1 {
+			continue
+		}
+		name, key := k[:i], k[i+1:]
+		if _, ok := existing[name]; ok {
+			continue
+		}
+		if contexts == nil {
+			contexts = make(map[string]map[string]string)
+		}
+		if contexts[name] == nil {
+			contexts[name] = make(map[string]string)
+		}
+		contexts[name][key] = value
+		d.omitList[k] = struct{}{}
+	}
+	return contexts
+}
+
+// hasAnyPrefix reports whether s starts with one of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
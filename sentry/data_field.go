@@ -1,9 +1,11 @@
 package sentry
 
 import (
-	"net/http"
+	"fmt"
+	"sort"
+	"strings"
 
-	"github.com/getsentry/raven-go"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,7 +15,7 @@ const (
 	fieldLogger      = "logger"
 	fieldServerName  = "server_name"
 	fieldTags        = "tags"
-	fieldHTTPRequest = "http_request"
+	fieldHTTPRequest = httpreq.Field
 	fieldUser        = "user"
 )
 
@@ -54,14 +56,93 @@ func (d *dataField) getServerName() (string, bool) {
 	return "", false
 }
 
-func (d *dataField) getTags() (raven.Tags, bool) {
-	if tags, ok := d.data[fieldTags].(raven.Tags); ok {
+func (d *dataField) getTags() (map[string]string, bool) {
+	if tags, ok := d.data[fieldTags].(map[string]string); ok {
 		d.omitList[fieldTags] = struct{}{}
 		return tags, true
 	}
 	return nil, false
 }
 
+// Tag is a field value that is sent as a tag, whether or not its field is a
+// tag field of the hook. Other hooks and formatters see a plain string.
+type Tag string
+
+// DefaultTagFields are the tag fields of new hooks, see Hook.AddTagField:
+// the method and code of the calls logged by the grpclogger interceptors.
+var DefaultTagFields = []string{"grpc.method", "grpc.code"}
+
+// tagKeys returns the keys of the fields sent as tags: the tag fields of the
+// hook, followed by the sorted keys of the fields holding a Tag.
+func (d *dataField) tagKeys(tagFields []string) []string {
+	var tagged []string
+	for k, v := range d.data {
+		if _, ok := v.(Tag); ok {
+			tagged = append(tagged, k)
+		}
+	}
+	sort.Strings(tagged)
+	return append(append([]string(nil), tagFields...), tagged...)
+}
+
+// Limits Sentry enforces on tags.
+const (
+	maxTagKeyLength   = 32
+	maxTagValueLength = 200
+)
+
+// getTag returns the value of the field key as a tag value if it is a
+// string, a number, a bool or a fmt.Stringer, see stringValue. Keys longer
+// than maxTagKeyLength are not valid tags and stay in the extra data; values
+// are cut to maxTagValueLength characters and newlines become spaces.
+func (d *dataField) getTag(key string) (string, bool) {
+	if key == "" || len(key) > maxTagKeyLength {
+		return "", false
+	}
+	value, ok := stringValue(d.data[key])
+	if !ok {
+		return "", false
+	}
+	value = strings.Replace(value, "\n", " ", -1)
+	value = truncate(value, maxTagValueLength)
+	d.omitList[key] = struct{}{}
+	return value, true
+}
+
+// stringValue returns v as a string if it is a string, a number, a bool or
+// a fmt.Stringer.
+func stringValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case Tag:
+		return string(v), true
+	case ContextValue:
+		return string(v), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+		return fmt.Sprint(v), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// truncate returns the first n characters of s, cutting at a rune
+// boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
+
 func (d *dataField) getFingerprint() ([]string, bool) {
 	if fingerprint, ok := d.data[fieldFingerprint].([]string); ok {
 		d.omitList[fieldFingerprint] = struct{}{}
@@ -78,12 +159,12 @@ func (d *dataField) getError() (error, bool) {
 	return nil, false
 }
 
-func (d *dataField) getHTTPRequest() (*raven.Http, bool) {
-	if req, ok := d.data[fieldHTTPRequest].(*http.Request); ok {
+func (d *dataField) getHTTPRequest() (*Request, bool) {
+	if req, ok := httpreq.HTTPRequest(d.data[fieldHTTPRequest]); ok {
 		d.omitList[fieldHTTPRequest] = struct{}{}
-		return raven.NewHttp(req), true
+		return NewRequest(req), true
 	}
-	if req, ok := d.data[fieldHTTPRequest].(*raven.Http); ok {
+	if req, ok := d.data[fieldHTTPRequest].(*Request); ok {
 		d.omitList[fieldHTTPRequest] = struct{}{}
 		return req, true
 	}
@@ -106,14 +187,14 @@ func (d *dataField) getEventID() (string, bool) {
 	return uuid.noDashString(), true
 }
 
-func (d *dataField) getUser() (*raven.User, bool) {
+func (d *dataField) getUser() (*User, bool) {
 	data := d.data
 	if v, ok := data[fieldUser]; ok {
 		switch val := v.(type) {
-		case *raven.User:
+		case *User:
 			d.omitList[fieldUser] = struct{}{}
 			return val, true
-		case raven.User:
+		case User:
 			d.omitList[fieldUser] = struct{}{}
 			return &val, true
 		}
@@ -128,7 +209,7 @@ func (d *dataField) getUser() (*raven.User, bool) {
 		return nil, false
 	}
 
-	return &raven.User{
+	return &User{
 		ID:       id,
 		Username: username,
 		Email:    email,
@@ -3,11 +3,13 @@ package sentry
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
 
-	"github.com/getsentry/raven-go"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -154,10 +156,11 @@ func TestGetTags(t *testing.T) {
 		expected    bool
 		description string
 	}{
-		{"tags", raven.Tags{{Key: "key", Value: "value"}}, true, "valid tags"},
-		{"tags", raven.Tags{}, true, "valid tags"},
-		{"not_tags", raven.Tags{{Key: "key", Value: "value"}}, false, "invalid key"},
-		{"tags", &raven.Tags{}, false, "invalid value type"},
+		{"tags", map[string]string{"key": "value"}, true, "valid tags"},
+		{"tags", map[string]string{}, true, "valid tags"},
+		{"not_tags", map[string]string{"key": "value"}, false, "invalid key"},
+		{"tags", &map[string]string{}, false, "invalid value type"},
+		{"tags", map[string]interface{}{}, false, "invalid value type"},
 		{"tags", "test_tags", false, "invalid value type"},
 		{"tags", 1, false, "invalid value type"},
 		{"tags", true, false, "invalid value type"},
@@ -182,6 +185,49 @@ func TestGetTags(t *testing.T) {
 	}
 }
 
+func TestGetTag(t *testing.T) {
+	assert := assert.New(t)
+
+	longKey := strings.Repeat("k", maxTagKeyLength+1)
+	tests := []struct {
+		key         string
+		value       interface{}
+		expected    bool
+		tag         string
+		description string
+	}{
+		{"tenant_id", "acme", true, "acme", "string"},
+		{"tenant_id", 42, true, "42", "int"},
+		{"tenant_id", 1.5, true, "1.5", "float"},
+		{"tenant_id", true, true, "true", "bool"},
+		{"tenant_id", net.IPv4(10, 0, 0, 1), true, "10.0.0.1", "stringer"},
+		{"tenant_id", Tag("acme"), true, "acme", "tag"},
+		{"tenant_id", "a\nb", true, "a b", "newline"},
+		{"tenant_id", strings.Repeat("v", 300), true, strings.Repeat("v", maxTagValueLength), "long value"},
+		{"tenant_id", strings.Repeat("é", 300), true, strings.Repeat("é", maxTagValueLength), "long multi-byte value"},
+		{"tenant_id", "a" + strings.Repeat("€", 199) + "b", true, "a" + strings.Repeat("€", 199), "multi-byte value cut at the limit"},
+		{longKey, "acme", false, "", "long key"},
+		{"tenant_id", []string{"acme"}, false, "", "invalid value type"},
+		{"tenant_id", struct{}{}, false, "", "invalid value type"},
+	}
+
+	for _, tt := range tests {
+		target := fmt.Sprintf("%+v", tt.description)
+
+		df := newDataField(logrus.Fields{tt.key: tt.value})
+		tag, ok := df.getTag(tt.key)
+		assert.Equal(tt.expected, ok, target)
+		assert.Equal(tt.expected, df.isOmit(tt.key), target)
+		if ok {
+			assert.Equal(tt.tag, tag, target)
+		}
+	}
+
+	df := newDataField(logrus.Fields{})
+	_, ok := df.getTag("missing")
+	assert.False(ok, "missing field")
+}
+
 func TestGetFingerprint(t *testing.T) {
 	assert := assert.New(t)
 
@@ -259,7 +305,7 @@ func TestGetHTTPRequest(t *testing.T) {
 	assert := assert.New(t)
 
 	httpReq, _ := http.NewRequest("GET", "/", nil)
-	ravenReq := raven.NewHttp(httpReq)
+	sentryReq := NewRequest(httpReq)
 
 	tests := []struct {
 		key         string
@@ -274,8 +320,9 @@ func TestGetHTTPRequest(t *testing.T) {
 		{"http_request", 1, false, "invalid value type"},
 		{"http_request", true, false, "invalid value type"},
 		{"http_request", struct{}{}, false, "invalid value type"},
-		{"http_request", raven.NewHttp(httpReq), true, "valid raven http_request"},
-		{"http_request", raven.Http{}, false, "invalid raven http_request"},
+		{"http_request", NewRequest(httpReq), true, "valid sentry http_request"},
+		{"http_request", httpreq.Value{Request: httpReq}, true, "valid wrapped http_request"},
+		{"http_request", Request{}, false, "invalid sentry http_request"},
 	}
 
 	for _, tt := range tests {
@@ -288,7 +335,7 @@ func TestGetHTTPRequest(t *testing.T) {
 		req, ok := df.getHTTPRequest()
 		assert.Equal(tt.expected, ok, target)
 		if ok {
-			assert.Equal(ravenReq, req, target)
+			assert.Equal(sentryReq, req, target)
 			assert.True(df.isOmit("http_request"), "`http_request` should be in omitList")
 		} else {
 			assert.False(df.isOmit("http_request"), "`http_request` should not be in omitList")
@@ -344,9 +391,9 @@ func TestGetUser(t *testing.T) {
 		expected    bool
 		description string
 	}{
-		{"user", &raven.User{}, true, "valid user"},
-		{"user", raven.User{}, true, "valid user"},
-		{"not_user", &raven.User{}, false, "invalid key"},
+		{"user", &User{}, true, "valid user"},
+		{"user", User{}, true, "valid user"},
+		{"not_user", &User{}, false, "invalid key"},
 		{"user", "test_user", false, "invalid value type"},
 		{"user", 1, false, "invalid value type"},
 		{"user", true, false, "invalid value type"},
@@ -363,7 +410,7 @@ func TestGetUser(t *testing.T) {
 		user, ok := df.getUser()
 		assert.Equal(tt.expected, ok, target)
 		if ok {
-			assert.IsType(&raven.User{}, user, target)
+			assert.IsType(&User{}, user, target)
 			assert.True(df.isOmit("user"), "`user` should be in omitList")
 		} else {
 			assert.False(df.isOmit("user"), "`user` should not be in omitList")
@@ -410,7 +457,7 @@ func TestGetUserFromString(t *testing.T) {
 		user, ok := df.getUser()
 		assert.Equal(tt.expected, ok, target)
 		if ok {
-			assert.IsType(&raven.User{}, user, target)
+			assert.IsType(&User{}, user, target)
 		}
 	}
 }
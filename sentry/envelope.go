@@ -0,0 +1,190 @@
+package sentry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/backoff"
+)
+
+// userAgent identifies the hook to the Sentry server.
+const userAgent = "logrus-hooks/sentry"
+
+// minCompressSize is the envelope size above which bodies are gzipped.
+const minCompressSize = 1000
+
+// eventCategory is the rate limit category of the events sent by the hook.
+const eventCategory = "error"
+
+// EnvelopeTransport is the Transport that delivers events to the envelope
+// endpoint of a Sentry server. All clients use it unless they are given a
+// custom transport.
+//
+// The transport honours the rate limits of the server: after a 429 response
+// or an X-Sentry-Rate-Limits header covering errors, it stops sending and
+// drops events with backoff.ErrRateLimited until the limit lifts. The event
+// rejected with a 429 fails with a backoff.RejectedError.
+type EnvelopeTransport struct {
+	// Client is the HTTP client used to send envelopes. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+
+	backoff backoff.Backoff
+}
+
+// NewEnvelopeTransport returns an EnvelopeTransport sending with client.
+func NewEnvelopeTransport(client *http.Client) *EnvelopeTransport {
+	return &EnvelopeTransport{Client: client}
+}
+
+// Send delivers event as an envelope.
+func (t *EnvelopeTransport) Send(url, authHeader string, event *Event) error {
+	if url == "" {
+		return nil
+	}
+	if t.backoff.Limited(eventCategory) {
+		return backoff.ErrRateLimited
+	}
+
+	env, err := newEventEnvelope(event)
+	if err != nil {
+		return err
+	}
+	body := &bytes.Buffer{}
+	if _, err := env.WriteTo(body); err != nil {
+		return fmt.Errorf("error serializing envelope: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return fmt.Errorf("can't create new request: %v", err)
+	}
+	if body.Len() > minCompressSize {
+		compressed := &bytes.Buffer{}
+		gz := gzip.NewWriter(compressed)
+		gz.Write(body.Bytes())
+		gz.Close()
+		body = compressed
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Body = ioutil.NopCloser(body)
+	req.ContentLength = int64(body.Len())
+	req.Header.Set("X-Sentry-Auth", authHeader)
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	t.updateRateLimits(res)
+	if res.StatusCode == http.StatusTooManyRequests {
+		return &backoff.RejectedError{Status: res.StatusCode, Until: t.backoff.Until(eventCategory)}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry: got http status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// RateLimitedUntil returns when the current rate limit on events lifts, or
+// the zero time when they are not rate limited.
+func (t *EnvelopeTransport) RateLimitedUntil() time.Time {
+	return t.backoff.Until(eventCategory)
+}
+
+// updateRateLimits applies the rate limits announced by a response. The
+// X-Sentry-Rate-Limits header is a comma separated list of
+// retry_after:categories:scope limits, where categories is a semicolon
+// separated list and empty means all categories. Without that header a 429
+// response limits everything for the duration of its Retry-After header.
+func (t *EnvelopeTransport) updateRateLimits(res *http.Response) {
+	limits := res.Header.Get("X-Sentry-Rate-Limits")
+	if limits == "" {
+		if res.StatusCode == http.StatusTooManyRequests {
+			t.backoff.Limit(backoff.All, backoff.RetryAfter(res.Header))
+		}
+		return
+	}
+	for _, limit := range strings.Split(limits, ",") {
+		parts := strings.Split(strings.TrimSpace(limit), ":")
+		seconds, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || seconds < 0 {
+			continue
+		}
+		d := time.Duration(seconds * float64(time.Second))
+		if len(parts) < 2 || parts[1] == "" {
+			t.backoff.Limit(backoff.All, d)
+			continue
+		}
+		for _, category := range strings.Split(parts[1], ";") {
+			t.backoff.Limit(category, d)
+		}
+	}
+}
+
+// envelope is the unit of the Sentry envelope protocol: a header followed by
+// any number of items, such as an event and its attachments.
+type envelope struct {
+	header map[string]interface{}
+	items  []envelopeItem
+}
+
+// envelopeItem is an item of an envelope.
+type envelopeItem struct {
+	header  map[string]interface{}
+	payload []byte
+}
+
+// newEventEnvelope returns an envelope carrying event as its event item.
+func newEventEnvelope(event *Event) (*envelope, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling event %+v to JSON: %v", event, err)
+	}
+	env := &envelope{header: map[string]interface{}{
+		"event_id": event.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+	env.add("event", payload)
+	return env, nil
+}
+
+// add appends an item of type typ.
+func (env *envelope) add(typ string, payload []byte) {
+	env.items = append(env.items, envelopeItem{
+		header:  map[string]interface{}{"type": typ, "length": len(payload)},
+		payload: payload,
+	})
+}
+
+// WriteTo serializes the envelope to w.
+func (env *envelope) WriteTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf) // Encode terminates every header with a newline
+	if err := enc.Encode(env.header); err != nil {
+		return 0, err
+	}
+	for _, item := range env.items {
+		if err := enc.Encode(item.header); err != nil {
+			return 0, err
+		}
+		buf.Write(item.payload)
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
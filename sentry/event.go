@@ -0,0 +1,143 @@
+package sentry
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/errchain"
+	"github.com/CIP-NL/logrus-hooks/httpreq"
+	"github.com/sirupsen/logrus"
+)
+
+// Severity is the level of an event or breadcrumb.
+type Severity string
+
+// The severities Sentry knows.
+const (
+	SeverityDebug   Severity = "debug"
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+	SeverityFatal   Severity = "fatal"
+)
+
+var (
+	severityMap = map[logrus.Level]Severity{
+		logrus.TraceLevel: SeverityDebug,
+		logrus.DebugLevel: SeverityDebug,
+		logrus.InfoLevel:  SeverityInfo,
+		logrus.WarnLevel:  SeverityWarning,
+		logrus.ErrorLevel: SeverityError,
+		logrus.FatalLevel: SeverityFatal,
+		logrus.PanicLevel: SeverityFatal,
+	}
+)
+
+// Event is the payload of an event item of an envelope, see
+// https://develop.sentry.dev/sdk/event-payloads/.
+type Event struct {
+	EventID     string                 `json:"event_id"`
+	Message     string                 `json:"message"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Level       Severity               `json:"level"`
+	Logger      string                 `json:"logger"`
+	Platform    string                 `json:"platform,omitempty"`
+	Culprit     string                 `json:"culprit,omitempty"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	Contexts    Contexts               `json:"contexts,omitempty"`
+	Exception   *Exceptions            `json:"exception,omitempty"`
+	Stacktrace  *Stacktrace            `json:"stacktrace,omitempty"`
+	Request     *Request               `json:"request,omitempty"`
+	User        *User                  `json:"user,omitempty"`
+	Breadcrumbs *Breadcrumbs           `json:"breadcrumbs,omitempty"`
+}
+
+// NewEvent returns an event with message, carrying the Go runtime as a
+// context.
+func NewEvent(message string) *Event {
+	return &Event{
+		Message:  message,
+		Platform: "go",
+		Contexts: Contexts{"runtime": map[string]string{
+			"name":    "go",
+			"version": runtime.Version(),
+		}},
+	}
+}
+
+// Exception is an error of an event.
+type Exception struct {
+	Value      string      `json:"value"`
+	Type       string      `json:"type,omitempty"`
+	Module     string      `json:"module,omitempty"`
+	Stacktrace *Stacktrace `json:"stacktrace,omitempty"`
+}
+
+// NewException returns err as an exception with stacktrace. Its type is the
+// name of the type of err, see errchain.TypeName.
+func NewException(err error, stacktrace *Stacktrace) *Exception {
+	return &Exception{
+		Value:      err.Error(),
+		Type:       errchain.TypeName(err),
+		Stacktrace: stacktrace,
+	}
+}
+
+// Exceptions is a chain of exceptions, innermost first.
+type Exceptions struct {
+	Values []*Exception `json:"values"`
+}
+
+// Stacktrace is a stack trace, with the oldest frame first.
+type Stacktrace struct {
+	Frames []*Frame `json:"frames"`
+}
+
+// Culprit returns the innermost in app function of the stack trace, or an
+// empty string when no frame is in app.
+func (s *Stacktrace) Culprit() string {
+	for i := len(s.Frames) - 1; i >= 0; i-- {
+		frame := s.Frames[i]
+		if frame.InApp && frame.Module != "" && frame.Function != "" {
+			return frame.Module + "." + frame.Function
+		}
+	}
+	return ""
+}
+
+// Frame is a frame of a stack trace.
+type Frame struct {
+	Filename     string   `json:"filename,omitempty"`
+	Function     string   `json:"function,omitempty"`
+	Module       string   `json:"module,omitempty"`
+	Lineno       int      `json:"lineno,omitempty"`
+	Colno        int      `json:"colno,omitempty"`
+	AbsolutePath string   `json:"abs_path,omitempty"`
+	ContextLine  string   `json:"context_line,omitempty"`
+	PreContext   []string `json:"pre_context,omitempty"`
+	PostContext  []string `json:"post_context,omitempty"`
+	InApp        bool     `json:"in_app"`
+}
+
+// Request is the HTTP request an event happened in. It is shared with the
+// Airbrake hook, see httpreq.Request.
+type Request = httpreq.Request
+
+// NewRequest returns req as a Request, see httpreq.New.
+func NewRequest(req *http.Request) *Request {
+	return httpreq.New(req)
+}
+
+// User is the user an event happened for.
+type User struct {
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	IP       string `json:"ip_address,omitempty"`
+}
@@ -1,62 +1,69 @@
 package sentry
 
 import (
-	"encoding/json"
 	"fmt"
+	"math"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/getsentry/raven-go"
+	"github.com/CIP-NL/logrus-hooks/backoff"
+	"github.com/CIP-NL/logrus-hooks/batch"
+	"github.com/CIP-NL/logrus-hooks/buildinfo"
+	"github.com/CIP-NL/logrus-hooks/callers"
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/errchain"
+	"github.com/CIP-NL/logrus-hooks/errhandler"
+	"github.com/CIP-NL/logrus-hooks/fieldfmt"
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/CIP-NL/logrus-hooks/tracing"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	severityMap = map[logrus.Level]raven.Severity{
-		logrus.DebugLevel: raven.DEBUG,
-		logrus.InfoLevel:  raven.INFO,
-		logrus.WarnLevel:  raven.WARNING,
-		logrus.ErrorLevel: raven.ERROR,
-		logrus.FatalLevel: raven.FATAL,
-		logrus.PanicLevel: raven.FATAL,
-	}
-)
-
 // Hook delivers logs to a sentry server.
 type Hook struct {
 	// Timeout sets the time to wait for a delivery error from the sentry server.
 	// If this is set to zero the server will not wait for any response and will
 	// consider the message correctly sent.
 	//
-	// This is ignored for asynchronous hooks. If you want to set a timeout when
-	// using an async hook (to bound the length of time that hook.Flush can take),
-	// you probably want to create your own raven.Client and set
-	// ravenClient.Transport.(*raven.HTTPTransport).Client.Timeout to set a
-	// timeout on the underlying HTTP request instead.
+	// This is ignored for asynchronous hooks, use FlushTimeout to bound the
+	// wait for their queue instead. To bound the time a single delivery can
+	// take, create your own Client and set the Timeout of the HTTP client of
+	// its EnvelopeTransport.
 	Timeout                 time.Duration
 	StacktraceConfiguration StackTraceConfiguration
-
-	client *raven.Client
-	levels []logrus.Level
+	// Name identifies the hook to the error handler. Defaults to "sentry".
+	Name string
+	// BeforeSend, when set, is called with every event built from an
+	// entry before it is sent, and may modify it or return a different one.
+	// Returning nil drops the event. The event is complete, including its ID
+	// and the tags, release and environment of the client.
+	BeforeSend func(entry *logrus.Entry, event *Event) *Event
+
+	client       *Client
+	errorHandler errhandler.ErrorHandler
+	stats        stats.Recorder
+	levels       []logrus.Level
 
 	serverName   string
+	breadcrumbs  *breadcrumbStore
 	ignoreFields map[string]struct{}
-	extraFilters map[string]func(interface{}) interface{}
+	tagFields    []string
+	// contextPrefixes are the prefixes of the fields sent in contexts.
+	contextPrefixes []string
+	extraFilters    map[string]func(interface{}) interface{}
 
-	asynchronous bool
+	// batcher queues the events in asynchronous mode, see NewAsyncHook.
+	batcher *batch.Batcher
 
 	mu sync.RWMutex
-	wg sync.WaitGroup
 }
 
-// The Stacktracer interface allows an error type to return a raven.Stacktrace.
+// The Stacktracer interface allows an error type to return a Stacktrace.
 type Stacktracer interface {
-	GetStacktrace() *raven.Stacktrace
-}
-
-type causer interface {
-	Cause() error
+	GetStacktrace() *Stacktrace
 }
 
 type pkgErrorStackTracer interface {
@@ -69,13 +76,16 @@ type StackTraceConfiguration struct {
 	Enable bool
 	// the level at which to start capturing stacktraces
 	Level logrus.Level
-	// how many stack frames to skip before stacktrace starts recording
+	// Deprecated: the stack trace starts at the call site of the entry,
+	// see callers.Stack. Skip is ignored.
 	Skip int
 	// the number of lines to include around a stack frame for context
 	Context int
 	// the prefixes that will be matched against the stack frame.
-	// if the stack frame's package matches one of these prefixes
-	// sentry will identify the stack frame as "in_app"
+	// if the stack frame's package is one of these prefixes or a package
+	// below one, sentry will identify the stack frame as "in_app".
+	// Defaults to the path of the main module. Packages in a vendor or
+	// third_party directory are never in app.
 	InAppPrefixes []string
 	// whether sending exception type should be enabled.
 	SendExceptionType bool
@@ -96,7 +106,7 @@ func New(dsn string) *Hook {
 	return nil
 }
 
-//Checks if the DSN is correct and if the sentry server is accessible
+// Checks if the DSN is correct and if the sentry server is accessible
 func (hook *Hook) Verify(dsn string) bool {
 	_, err := NewHook(dsn, []logrus.Level{
 		logrus.PanicLevel,
@@ -107,30 +117,45 @@ func (hook *Hook) Verify(dsn string) bool {
 }
 
 // NewHook creates a hook to be added to an instance of logger
-// and initializes the raven client.
+// and initializes the client.
 // This method sets the timeout to 100 milliseconds.
 func NewHook(DSN string, levels []logrus.Level) (*Hook, error) {
-	client, err := raven.New(DSN)
+	client, err := NewClient(DSN)
 	if err != nil {
 		return nil, err
 	}
+	setDefaultEnvironment(client)
 	return NewWithClientHook(client, levels)
 }
 
 // NewWithTagsHook creates a hook with tags to be added to an instance
-// of logger and initializes the raven client. This method sets the timeout to
+// of logger and initializes the client. This method sets the timeout to
 // 100 milliseconds.
 func NewWithTagsHook(DSN string, tags map[string]string, levels []logrus.Level) (*Hook, error) {
-	client, err := raven.NewWithTags(DSN, tags)
+	client, err := NewClientWithTags(DSN, tags)
 	if err != nil {
 		return nil, err
 	}
+	setDefaultEnvironment(client)
 	return NewWithClientHook(client, levels)
 }
 
-// NewWithClientHook creates a hook using an initialized raven client.
+// setDefaultEnvironment sets the environment of a client created by the hook
+// from the buildinfo.EnvironmentVar environment variable.
+func setDefaultEnvironment(client *Client) {
+	if env := buildinfo.Environment(); env != "" {
+		client.SetEnvironment(env)
+	}
+}
+
+// NewWithClientHook creates a hook using an initialized client.
 // This method sets the timeout to 100 milliseconds.
-func NewWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, error) {
+// A client without release gets the one of the program, see
+// buildinfo.Release.
+func NewWithClientHook(client *Client, levels []logrus.Level) (*Hook, error) {
+	if client.Release() == "" {
+		client.SetRelease(buildinfo.Release())
+	}
 	return &Hook{
 		Timeout: 100 * time.Millisecond,
 		StacktraceConfiguration: StackTraceConfiguration{
@@ -138,16 +163,29 @@ func NewWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, erro
 			Level:             logrus.ErrorLevel,
 			Skip:              5,
 			Context:           0,
-			InAppPrefixes:     nil,
+			InAppPrefixes:     defaultInAppPrefixes(),
 			SendExceptionType: true,
 		},
-		client:       client,
-		levels:       levels,
-		ignoreFields: make(map[string]struct{}),
-		extraFilters: make(map[string]func(interface{}) interface{}),
+		Name:            "sentry",
+		client:          client,
+		errorHandler:    errhandler.Stderr,
+		levels:          levels,
+		ignoreFields:    make(map[string]struct{}),
+		tagFields:       append([]string(nil), DefaultTagFields...),
+		contextPrefixes: append([]string(nil), DefaultContextPrefixes...),
+		extraFilters:    make(map[string]func(interface{}) interface{}),
 	}, nil
 }
 
+// defaultInAppPrefixes returns the path of the main module, see
+// buildinfo.MainModule, as the only prefix of in app frames.
+func defaultInAppPrefixes() []string {
+	if module := buildinfo.MainModule(); module != "" {
+		return []string{module}
+	}
+	return nil
+}
+
 // NewAsyncHook creates a hook same as NewHook, but in asynchronous
 // mode.
 func NewAsyncHook(DSN string, levels []logrus.Level) (*Hook, error) {
@@ -164,179 +202,380 @@ func NewAsyncWithTagsHook(DSN string, tags map[string]string, levels []logrus.Le
 
 // NewAsyncWithClientHook creates a hook same as NewWithClientHook,
 // but in asynchronous mode.
-func NewAsyncWithClientHook(client *raven.Client, levels []logrus.Level) (*Hook, error) {
+func NewAsyncWithClientHook(client *Client, levels []logrus.Level) (*Hook, error) {
 	hook, err := NewWithClientHook(client, levels)
 	return setAsync(hook), err
 }
 
+// setAsync queues the events of hook on a batch.Batcher, in batches of one
+// as Sentry takes an event per envelope.
 func setAsync(hook *Hook) *Hook {
 	if hook == nil {
 		return nil
 	}
-	hook.asynchronous = true
+	hook.batcher = batch.New(hook.Name, batch.Config{
+		MaxEntries: 1,
+		QueueSize:  MaxQueueBuffer,
+	}, nil, hook.sendItems)
+	// the hook reports the outcome of its events itself
+	hook.batcher.SetErrorHandler(nil)
 	return hook
 }
 
 // Fire is called when an event should be sent to sentry
 // Special fields that sentry uses to give more information to the server
-// are extracted from entry.Data (if they are found)
-// These fields are: error, logger, server_name, http_request, tags
+// are extracted from entry.Data and the fields of entry.Context, see
+// ctxfields (if they are found)
+// These fields are: error, logger, server_name, http_request, tags, trace_id,
+// span_id
+// Fields holding a Tag and the tag fields are sent as tags; fields holding a
+// ContextValue and those matching a context prefix are sent in contexts.
 func (hook *Hook) Fire(entry *logrus.Entry) error {
 	hook.mu.RLock() // Allow multiple go routines to log simultaneously
 	defer hook.mu.RUnlock()
-	packet := raven.NewPacket(entry.Message)
-	packet.Timestamp = raven.Timestamp(entry.Time)
-	packet.Level = severityMap[entry.Level]
-	packet.Platform = "go"
+	defer func(start time.Time) { hook.stats.ObserveFire(time.Since(start)) }(time.Now())
 
-	df := newDataField(entry.Data)
+	if hook.breadcrumbs != nil && !hook.Captures(entry.Level) {
+		hook.breadcrumbs.add(entry)
+		return nil
+	}
+
+	event := NewEvent(entry.Message)
+	event.Timestamp = entry.Time
+	event.Level = severityMap[entry.Level]
+
+	df := newDataField(ctxfields.Merge(entry))
 
 	// set special fields
 	if hook.serverName != "" {
-		packet.ServerName = hook.serverName
+		event.ServerName = hook.serverName
 	}
 	if logger, ok := df.getLogger(); ok {
-		packet.Logger = logger
+		event.Logger = logger
 	}
 	if serverName, ok := df.getServerName(); ok {
-		packet.ServerName = serverName
+		event.ServerName = serverName
 	}
 	if eventID, ok := df.getEventID(); ok {
-		packet.EventID = eventID
+		event.EventID = eventID
 	}
 	if tags, ok := df.getTags(); ok {
-		packet.Tags = tags
+		// copied, as the tag fields and the client add to them
+		event.Tags = make(map[string]string, len(tags))
+		for k, v := range tags {
+			event.Tags[k] = v
+		}
+	}
+	for _, key := range df.tagKeys(hook.tagFields) {
+		if _, ok := event.Tags[key]; ok {
+			continue // explicit tags win
+		}
+		if value, ok := df.getTag(key); ok {
+			setTag(event, key, value)
+		}
 	}
 	if fingerprint, ok := df.getFingerprint(); ok {
-		packet.Fingerprint = fingerprint
+		event.Fingerprint = fingerprint
 	}
 	if req, ok := df.getHTTPRequest(); ok {
-		packet.Interfaces = append(packet.Interfaces, req)
+		event.Request = req
 	}
 	if user, ok := df.getUser(); ok {
-		packet.Interfaces = append(packet.Interfaces, user)
+		event.User = user
+	}
+	if trace, ok := df.getTrace(entry); ok {
+		event.Contexts["trace"] = trace
+		if _, ok := event.Tags[tracing.FieldTraceID]; !ok {
+			setTag(event, tracing.FieldTraceID, trace.TraceID)
+		}
+	}
+	for name, values := range df.getContexts(event.Contexts, hook.contextPrefixes) {
+		event.Contexts[name] = values
 	}
 
 	// set stacktrace data
 	stConfig := &hook.StacktraceConfiguration
 	if stConfig.Enable && entry.Level <= stConfig.Level {
 		if err, ok := df.getError(); ok {
-			var currentStacktrace *raven.Stacktrace
+			var currentStacktrace *Stacktrace
 			currentStacktrace = hook.findStacktrace(err)
 			if currentStacktrace == nil {
-				currentStacktrace = raven.NewStacktrace(stConfig.Skip, stConfig.Context, stConfig.InAppPrefixes)
-			}
-			cause := errors.Cause(err)
-			if cause == nil {
-				cause = err
-			}
-			exc := raven.NewException(cause, currentStacktrace)
-			if !stConfig.SendExceptionType {
-				exc.Type = ""
+				currentStacktrace = hook.callerStacktrace(entry)
 			}
 			if stConfig.SwitchExceptionTypeAndMessage {
-				packet.Interfaces = append(packet.Interfaces, currentStacktrace)
-				packet.Culprit = exc.Type + ": " + currentStacktrace.Culprit()
+				cause := errors.Cause(err)
+				if cause == nil {
+					cause = err
+				}
+				var parts []string
+				if stConfig.SendExceptionType {
+					parts = append(parts, errchain.TypeName(cause))
+				}
+				if currentStacktrace != nil {
+					event.Stacktrace = currentStacktrace
+					parts = append(parts, currentStacktrace.Culprit())
+				} else if len(parts) == 0 {
+					parts = append(parts, err.Error())
+				}
+				event.Culprit = strings.Join(parts, ": ")
 			} else {
-				packet.Interfaces = append(packet.Interfaces, exc)
-				packet.Culprit = err.Error()
+				event.Exception = hook.exceptions(err, currentStacktrace)
+				event.Culprit = err.Error()
 			}
 		} else {
-			currentStacktrace := raven.NewStacktrace(stConfig.Skip, stConfig.Context, stConfig.InAppPrefixes)
-			if currentStacktrace != nil {
-				packet.Interfaces = append(packet.Interfaces, currentStacktrace)
-			}
+			event.Stacktrace = hook.callerStacktrace(entry)
+			event.Culprit = culprit(entry)
 		}
 	} else {
 		// set the culprit even when the stack trace is disabled, as long as we have an error
 		if err, ok := df.getError(); ok {
-			packet.Culprit = err.Error()
+			event.Culprit = err.Error()
+		} else {
+			event.Culprit = culprit(entry)
 		}
 	}
 
 	// set other fields
-	dataExtra := hook.formatExtraData(df)
-	if packet.Extra == nil {
-		packet.Extra = dataExtra
-	} else {
-		for k, v := range dataExtra {
-			packet.Extra[k] = v
+	event.Extra = hook.formatExtraData(df)
+
+	if hook.breadcrumbs != nil {
+		if crumbs := hook.breadcrumbs.take(entry); len(crumbs) > 0 {
+			event.Breadcrumbs = &Breadcrumbs{Values: crumbs}
 		}
 	}
 
-	_, errCh := hook.client.Capture(packet, nil)
+	if err := hook.client.populate(event); err != nil {
+		hook.report(event, err)
+		return err
+	}
+	if hook.BeforeSend != nil {
+		if event = hook.BeforeSend(entry, event); event == nil {
+			hook.stats.Dropped()
+			return nil
+		}
+	}
 
-	if hook.asynchronous {
-		// Our use of hook.mu guarantees that we are following the WaitGroup rule of
-		// not calling Add in parallel with Wait.
-		hook.wg.Add(1)
-		go func() {
-			if err := <-errCh; err != nil {
-				fmt.Println(err)
-			}
-			hook.wg.Done()
-		}()
+	if hook.batcher != nil {
+		switch hook.batcher.AddItem(batch.Item{Entry: batch.Snapshot(entry), Value: event}) {
+		case batch.ErrQueueFull, batch.ErrClosed:
+			hook.report(event, ErrEventDropped)
+		}
 		return nil
-	} else if timeout := hook.Timeout; timeout == 0 {
+	}
+
+	errCh := hook.client.enqueue(event)
+	if timeout := hook.Timeout; timeout == 0 {
 		return nil
 	} else {
 		timeoutCh := time.After(timeout)
 		select {
 		case err := <-errCh:
+			hook.report(event, err)
+			if err == backoff.ErrRateLimited {
+				return nil
+			}
 			return err
 		case <-timeoutCh:
-			return fmt.Errorf("no response from sentry server in %s", timeout)
+			err := fmt.Errorf("no response from sentry server in %s", timeout)
+			hook.report(event, err)
+			return err
 		}
 	}
 }
 
+// report records the outcome of a delivery and passes failures on to the
+// error handler. Events dropped while the server rate limits the hook are
+// only counted.
+func (hook *Hook) report(event *Event, err error) {
+	switch err {
+	case nil:
+		hook.stats.Sent()
+		return
+	case backoff.ErrRateLimited:
+		hook.stats.Dropped()
+		return
+	case ErrEventDropped:
+		hook.stats.Dropped()
+	default:
+		hook.stats.Failed(err)
+	}
+	if hook.errorHandler != nil {
+		hook.errorHandler.HandleError(hook.Name, event, err)
+	}
+}
+
+// sendItems is the batch.Sender of an asynchronous hook. An event the server
+// rejected with a rate limit is sent again once the back-off lifts, see
+// MaxRetryWait. The outcome of the events is recorded by report, so no error
+// is handed back to the batcher.
+func (hook *Hook) sendItems(items []batch.Item) []error {
+	for _, item := range items {
+		event := item.Value.(*Event)
+		hook.stats.AddInFlight(1)
+		err := <-hook.client.enqueue(event)
+		if wait, ok := backoff.RetryWait(err, MaxRetryWait); ok {
+			time.Sleep(wait)
+			hook.stats.Retried()
+			err = <-hook.client.enqueue(event)
+		}
+		hook.report(event, err)
+		hook.stats.AddInFlight(-1)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the delivery statistics of the hook. The
+// queue depth of an asynchronous hook is that of its batcher.
+func (hook *Hook) Stats() stats.Snapshot {
+	s := hook.stats.Snapshot(hook.Name)
+	if hook.batcher != nil {
+		s.QueueDepth = hook.batcher.Stats().QueueDepth
+	}
+	return s
+}
+
+// Health returns the current delivery health of the hook. The queue
+// saturation of an asynchronous hook is the fill level of its queue. The
+// circuit is open while the server rate limits the hook.
+func (hook *Hook) Health() stats.Health {
+	h := hook.stats.Health(hook.Name)
+	if t, ok := hook.client.Transport.(*EnvelopeTransport); ok && !t.RateLimitedUntil().IsZero() {
+		h.Circuit = stats.CircuitOpen
+	}
+	if hook.batcher != nil {
+		h.QueueSaturation = hook.batcher.Health().QueueSaturation
+	}
+	return h
+}
+
+// SetErrorHandler sets the handler delivery failures are reported to.
+func (hook *Hook) SetErrorHandler(handler errhandler.ErrorHandler) {
+	hook.errorHandler = handler
+}
+
 // Flush waits for the log queue to empty. This function only does anything in
 // asynchronous mode.
 func (hook *Hook) Flush() {
-	if !hook.asynchronous {
+	if hook.batcher == nil {
 		return
 	}
-	hook.mu.Lock() // Claim exclusive access; any logging goroutines will block until the flush completes
-	defer hook.mu.Unlock()
+	hook.batcher.Flush(time.Duration(math.MaxInt64))
+}
 
-	hook.wg.Wait()
+// FlushTimeout waits until every queued event was delivered or timeout
+// elapses, and returns ErrFlushTimeout in the latter case. It only does
+// anything in asynchronous mode.
+func (hook *Hook) FlushTimeout(timeout time.Duration) error {
+	if hook.batcher == nil {
+		return nil
+	}
+	if err := hook.batcher.Flush(timeout); err != nil {
+		return ErrFlushTimeout
+	}
+	return nil
 }
 
-func (hook *Hook) findStacktrace(err error) *raven.Stacktrace {
-	var stacktrace *raven.Stacktrace
-	var stackErr errors.StackTrace
-	for err != nil {
-		// Find the earliest *raven.Stacktrace, or error.StackTrace
-		if tracer, ok := err.(Stacktracer); ok {
-			stacktrace = tracer.GetStacktrace()
-			stackErr = nil
-		} else if tracer, ok := err.(pkgErrorStackTracer); ok {
-			stacktrace = nil
-			stackErr = tracer.StackTrace()
-		}
-		if cause, ok := err.(causer); ok {
-			err = cause.Cause()
-		} else {
-			break
+// Close stops accepting events, sends the queued ones and waits for them to
+// be delivered. It only does anything in asynchronous mode; the events fired
+// afterwards are dropped.
+func (hook *Hook) Close() error {
+	if hook.batcher == nil {
+		return nil
+	}
+	return hook.batcher.Close()
+}
+
+// callerStacktrace returns the stack trace from the call site of entry, see
+// callers.Stack, or nil when the call site is not known.
+func (hook *Hook) callerStacktrace(entry *logrus.Entry) *Stacktrace {
+	stConfig := &hook.StacktraceConfiguration
+	stack, _ := callers.Stack(entry)
+	frames := make([]*Frame, 0, len(stack))
+	// Sentry wants the frames with the oldest first
+	for i := len(stack) - 1; i >= 0; i-- {
+		f := stack[i]
+		frame := newFrame(f.Function, f.File, f.Line, stConfig.Context, stConfig.InAppPrefixes)
+		if frame != nil {
+			frames = append(frames, frame)
 		}
 	}
-	if stackErr != nil {
-		stacktrace = hook.convertStackTrace(stackErr)
+	if len(frames) == 0 {
+		return nil
+	}
+	return &Stacktrace{Frames: frames}
+}
+
+// culprit returns the function entry was logged from, or an empty string
+// when it is not known.
+func culprit(entry *logrus.Entry) string {
+	if stack, _ := callers.Stack(entry); len(stack) > 0 {
+		return stack[0].Function
+	}
+	return ""
+}
+
+// findStacktrace returns the stack trace of the innermost error of the chain
+// of err that has one.
+func (hook *Hook) findStacktrace(err error) *Stacktrace {
+	var stacktrace *Stacktrace
+	for _, link := range errchain.Chain(err) {
+		if st := hook.linkStacktrace(link); st != nil {
+			stacktrace = st
+		}
 	}
 	return stacktrace
 }
 
+// linkStacktrace returns the stack trace carried by a link of an error chain.
+func (hook *Hook) linkStacktrace(link errchain.Link) *Stacktrace {
+	for _, layer := range link.Layers {
+		if tracer, ok := layer.(Stacktracer); ok {
+			return tracer.GetStacktrace()
+		}
+		if tracer, ok := layer.(pkgErrorStackTracer); ok {
+			return hook.convertStackTrace(tracer.StackTrace())
+		}
+	}
+	return nil
+}
+
+// exceptions returns the chain of err as exceptions, innermost first as
+// Sentry expects. Each exception has the stack trace of its own error, if
+// any. When no error in the chain has one, the outermost exception gets
+// fallback.
+func (hook *Hook) exceptions(err error, fallback *Stacktrace) *Exceptions {
+	links := errchain.Chain(err)
+	values := make([]*Exception, len(links))
+	found := false
+	for i, link := range links {
+		st := hook.linkStacktrace(link)
+		found = found || st != nil
+		exc := NewException(link.Err, st)
+		if !hook.StacktraceConfiguration.SendExceptionType {
+			exc.Type = ""
+		}
+		values[len(links)-1-i] = exc
+	}
+	if !found && len(values) > 0 {
+		values[len(values)-1].Stacktrace = fallback
+	}
+	return &Exceptions{Values: values}
+}
+
 // convertStackTrace converts an errors.StackTrace into a natively consumable
-// *raven.Stacktrace
-func (hook *Hook) convertStackTrace(st errors.StackTrace) *raven.Stacktrace {
+// *Stacktrace
+func (hook *Hook) convertStackTrace(st errors.StackTrace) *Stacktrace {
 	stConfig := &hook.StacktraceConfiguration
 	stFrames := []errors.Frame(st)
-	frames := make([]*raven.StacktraceFrame, 0, len(stFrames))
+	frames := make([]*Frame, 0, len(stFrames))
 	for i := range stFrames {
-		pc := uintptr(stFrames[i])
+		pc := uintptr(stFrames[i]) - 1 // the frame holds the return address
 		fn := runtime.FuncForPC(pc)
+		if fn == nil {
+			continue
+		}
 		file, line := fn.FileLine(pc)
-		frame := raven.NewStacktraceFrame(pc, file, line, stConfig.Context, stConfig.InAppPrefixes)
+		frame := newFrame(fn.Name(), file, line, stConfig.Context, stConfig.InAppPrefixes)
 		if frame != nil {
 			frames = append(frames, frame)
 		}
@@ -346,11 +585,15 @@ func (hook *Hook) convertStackTrace(st errors.StackTrace) *raven.Stacktrace {
 	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
 		frames[i], frames[j] = frames[j], frames[i]
 	}
-	return &raven.Stacktrace{Frames: frames}
+	return &Stacktrace{Frames: frames}
 }
 
-// Levels returns the available logging levels.
+// Levels returns the available logging levels, or all levels when
+// breadcrumbs are enabled.
 func (hook *Hook) Levels() []logrus.Level {
+	if hook.breadcrumbs != nil {
+		return logrus.AllLevels
+	}
 	return hook.levels
 }
 
@@ -374,6 +617,37 @@ func (hook *Hook) AddIgnore(name string) {
 	hook.ignoreFields[name] = struct{}{}
 }
 
+// AddTagField promotes the field name to a tag. Strings, numbers, bools and
+// fmt.Stringer values of the field are sent as tags instead of extra data.
+func (hook *Hook) AddTagField(name string) {
+	for _, f := range hook.tagFields {
+		if f == name {
+			return
+		}
+	}
+	hook.tagFields = append(hook.tagFields, name)
+}
+
+// AddContextPrefix sends the fields whose key starts with prefix in a
+// context instead of the extra data, like fields holding a ContextValue.
+// The prefix should end with a dot, e.g. "db.".
+func (hook *Hook) AddContextPrefix(prefix string) {
+	for _, p := range hook.contextPrefixes {
+		if p == prefix {
+			return
+		}
+	}
+	hook.contextPrefixes = append(hook.contextPrefixes, prefix)
+}
+
+// setTag sets the tag key of event to value.
+func setTag(event *Event, key, value string) {
+	if event.Tags == nil {
+		event.Tags = make(map[string]string)
+	}
+	event.Tags[key] = value
+}
+
 // AddExtraFilter adds a custom filter function.
 func (hook *Hook) AddExtraFilter(name string, fn func(interface{}) interface{}) {
 	hook.extraFilters[name] = fn
@@ -400,18 +674,9 @@ func (hook *Hook) formatExtraData(df *dataField) (result map[string]interface{})
 	return result
 }
 
-// formatData returns value as a suitable format.
+// formatData returns value as a suitable format, see fieldfmt.Format.
 func formatData(value interface{}) (formatted interface{}) {
-	switch value := value.(type) {
-	case json.Marshaler:
-		return value
-	case error:
-		return value.Error()
-	case fmt.Stringer:
-		return value.String()
-	default:
-		return value
-	}
+	return fieldfmt.Format(value)
 }
 
 // Sends a dummy error to the server
@@ -1,8 +1,9 @@
 package sentry
 
 import (
-	"compress/zlib"
-	"encoding/base64"
+	"bufio"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,16 +11,21 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/getsentry/raven-go"
+	"github.com/CIP-NL/logrus-hooks/buildinfo"
+	"github.com/CIP-NL/logrus-hooks/ctxfields"
+	"github.com/CIP-NL/logrus-hooks/tracing"
 	"github.com/joho/godotenv"
 	pkgerrors "github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -39,12 +45,19 @@ var (
 	integration bool
 )
 
-// raven.Packet does not have a json directive for deserializing stacktrace
-// so need to explicitly construct one for purpose of test
+// resultPacket is a received Event with values rather than pointers for its
+// optional parts, so that tests can inspect them without nil checks
 type resultPacket struct {
-	raven.Packet
-	Stacktrace raven.Stacktrace `json:"stacktrace"`
-	Exception  raven.Exception  `json:"exception"`
+	Event
+	Stacktrace  Stacktrace  `json:"stacktrace"`
+	Exception   Exceptions  `json:"exception"`
+	Breadcrumbs Breadcrumbs `json:"breadcrumbs"`
+	User        User        `json:"user"`
+	Contexts    struct {
+		Trace TraceContext      `json:"trace"`
+		GRPC  map[string]string `json:"grpc"`
+		DB    map[string]string `json:"db"`
+	} `json:"contexts"`
 }
 
 func init() {
@@ -69,22 +82,10 @@ func init() {
 func WithTestDSN(t *testing.T, tf func(string, <-chan *resultPacket)) {
 	pch := make(chan *resultPacket, 1)
 	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		defer req.Body.Close()
-		contentType := req.Header.Get("Content-Type")
-		var bodyReader io.Reader = req.Body
-		// underlying client will compress and encode payload above certain size
-		if contentType == "application/octet-stream" {
-			bodyReader = base64.NewDecoder(base64.StdEncoding, bodyReader)
-			bodyReader, _ = zlib.NewReader(bodyReader)
-		}
-
-		d := json.NewDecoder(bodyReader)
-		p := &resultPacket{}
-		err := d.Decode(p)
+		p, err := readEnvelopeEvent(req)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
-
 		pch <- p
 	}))
 	defer s.Close()
@@ -98,6 +99,65 @@ func WithTestDSN(t *testing.T, tf func(string, <-chan *resultPacket)) {
 	tf(dsn, pch)
 }
 
+// readEnvelopeEvent decodes the event item of an envelope posted to the
+// envelope endpoint.
+func readEnvelopeEvent(req *http.Request) (*resultPacket, error) {
+	defer req.Body.Close()
+	if req.URL.Path != "/sentry/api/project-id/envelope/" {
+		return nil, fmt.Errorf("unexpected endpoint %s", req.URL.Path)
+	}
+	var body io.Reader = req.Body
+	// the transport gzips envelopes above a certain size
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		body = gz
+	}
+	r := bufio.NewReader(body)
+
+	var header struct {
+		EventID string `json:"event_id"`
+	}
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, err
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("no event in envelope: %v", err)
+		}
+		var item struct {
+			Type   string `json:"type"`
+			Length int    `json:"length"`
+		}
+		if err := json.Unmarshal(line, &item); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, item.Length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		r.ReadByte() // newline after the payload
+		if item.Type != "event" {
+			continue
+		}
+		p := &resultPacket{}
+		if err := json.Unmarshal(payload, p); err != nil {
+			return nil, err
+		}
+		if p.EventID != header.EventID {
+			return nil, fmt.Errorf("envelope is for event %s, payload is event %s", header.EventID, p.EventID)
+		}
+		return p, nil
+	}
+}
+
 func TestServerName(t *testing.T) {
 	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
 		logger := getTestLogger()
@@ -122,6 +182,38 @@ func TestServerName(t *testing.T) {
 	})
 }
 
+func TestReleaseAndEnvironment(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		defer os.Setenv(buildinfo.EnvironmentVar, os.Getenv(buildinfo.EnvironmentVar))
+		os.Setenv(buildinfo.EnvironmentVar, "staging")
+
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if hook.client.Release() != buildinfo.Release() {
+			t.Errorf("release should default to %q, was %q", buildinfo.Release(), hook.client.Release())
+		}
+		hook.SetRelease("app@v1.2.0")
+		logger.Hooks.Add(hook)
+
+		logger.Error(message)
+		packet := <-pch
+		if packet.Release != "app@v1.2.0" {
+			t.Errorf("release should have been app@v1.2.0, was %s", packet.Release)
+		}
+		if packet.Environment != "staging" {
+			t.Errorf("environment should have been staging, was %s", packet.Environment)
+		}
+		if packet.ServerName != buildinfo.ServerName() {
+			t.Errorf("server_name should have been %s, was %s", buildinfo.ServerName(), packet.ServerName)
+		}
+	})
+}
+
 func TestSpecialFields(t *testing.T) {
 	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
 		logger := getTestLogger()
@@ -176,7 +268,7 @@ func TestSentryWithClient(t *testing.T) {
 	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
 		logger := getTestLogger()
 
-		client, _ := raven.New(dsn)
+		client, _ := NewClient(dsn)
 
 		hook, err := NewWithClientHook(client, []logrus.Level{
 			logrus.ErrorLevel,
@@ -198,7 +290,7 @@ func TestSentryWithClientAndError(t *testing.T) {
 	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
 		logger := getTestLogger()
 
-		client, _ := raven.New(dsn)
+		client, _ := NewClient(dsn)
 
 		hook, err := NewWithClientHook(client, []logrus.Level{
 			logrus.ErrorLevel,
@@ -239,11 +331,8 @@ func TestSentryTags(t *testing.T) {
 
 		logger.Error(message)
 		packet := <-pch
-		expected := raven.Tags{
-			raven.Tag{
-				Key:   "site",
-				Value: "test",
-			},
+		expected := map[string]string{
+			"site": "test",
 		}
 		if !reflect.DeepEqual(packet.Tags, expected) {
 			t.Errorf("tags should have been %+v, was %+v", expected, packet.Tags)
@@ -251,6 +340,172 @@ func TestSentryTags(t *testing.T) {
 	})
 }
 
+func TestTagFields(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.AddTagField("tenant_id")
+		hook.AddTagField("route")
+		hook.AddTagField("payload")
+		hook.AddTagField("site")
+		logger.Hooks.Add(hook)
+
+		logger.WithFields(logrus.Fields{
+			"tenant_id": 42,
+			"route":     "/orders",
+			"payload":   map[string]int{"n": 1},
+			"site":      "field",
+			"tags":      map[string]string{"site": "explicit"},
+			"method":    Tag("GET"),
+			"code":      Tag("200"),
+		}).Error(message)
+
+		packet := <-pch
+		expected := map[string]string{
+			"site":      "explicit",
+			"tenant_id": "42",
+			"route":     "/orders",
+			"code":      "200",
+			"method":    "GET",
+		}
+		if !reflect.DeepEqual(packet.Tags, expected) {
+			t.Errorf("tags should have been %+v, was %+v", expected, packet.Tags)
+		}
+		for _, key := range []string{"tenant_id", "route", "method", "code"} {
+			if _, ok := packet.Extra[key]; ok {
+				t.Errorf("%s should have been removed from extra", key)
+			}
+		}
+		for _, key := range []string{"payload", "site"} {
+			if _, ok := packet.Extra[key]; !ok {
+				t.Errorf("%s should have stayed in extra", key)
+			}
+		}
+	})
+}
+
+func TestSentryTraceContext(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		logger.Hooks.Add(hook)
+
+		traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+		spanID, _ := trace.SpanIDFromHex("0102030405060708")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+		tracing.WithContext(logrus.NewEntry(logger), ctx).Error(message)
+
+		packet := <-pch
+		expected := TraceContext{TraceID: traceID.String(), SpanID: spanID.String(), Type: "trace"}
+		if packet.Contexts.Trace != expected {
+			t.Errorf("trace context should have been %+v, was %+v", expected, packet.Contexts.Trace)
+		}
+		if !reflect.DeepEqual(packet.Tags, map[string]string{"trace_id": traceID.String()}) {
+			t.Errorf("the trace ID should have been a tag, tags were %+v", packet.Tags)
+		}
+		for _, key := range []string{"trace_id", "span_id"} {
+			if _, ok := packet.Extra[key]; ok {
+				t.Errorf("%s should have been removed from extra", key)
+			}
+		}
+
+		logger.Error(message)
+		packet = <-pch
+		if packet.Contexts.Trace != (TraceContext{}) {
+			t.Errorf("entries without a trace should not have a trace context, got %+v", packet.Contexts.Trace)
+		}
+	})
+}
+
+func TestContextValues(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.AddContextPrefix("db.")
+		logger.Hooks.Add(hook)
+
+		logger.WithFields(logrus.Fields{
+			"grpc.target":                "passthrough:///svc",
+			"grpc.method":                "/svc/Get",
+			"db.system":                  "postgres",
+			"grpc.peer":                  ContextValue("10.0.0.1:1234"),
+			"grpc.metadata.x-request-id": ContextValue("abc"),
+			"nodot":                      ContextValue("kept"),
+			"runtime.name":               ContextValue("taken"),
+		}).Error(message)
+
+		packet := <-pch
+		expected := map[string]string{"peer": "10.0.0.1:1234", "metadata.x-request-id": "abc", "target": "passthrough:///svc"}
+		if !reflect.DeepEqual(packet.Contexts.GRPC, expected) {
+			t.Errorf("grpc context should have been %v, was %v", expected, packet.Contexts.GRPC)
+		}
+		if packet.Contexts.DB["system"] != "postgres" {
+			t.Errorf("db.system should have been sent in the db context, was %v", packet.Contexts.DB)
+		}
+		if packet.Tags["grpc.method"] != "/svc/Get" {
+			t.Errorf("grpc.method should have been sent as a tag, tags were %v", packet.Tags)
+		}
+		for _, key := range []string{"grpc.peer", "grpc.metadata.x-request-id", "grpc.target", "grpc.method", "db.system"} {
+			if _, ok := packet.Extra[key]; ok {
+				t.Errorf("%s should have been removed from extra", key)
+			}
+		}
+		if packet.Extra["nodot"] != "kept" || packet.Extra["runtime.name"] != "taken" {
+			t.Errorf("fields without a free context name should stay in extra, extra was %v", packet.Extra)
+		}
+	})
+}
+
+type tenantKey struct{}
+
+func TestContextFields(t *testing.T) {
+	ctxfields.Register(func(ctx context.Context) logrus.Fields {
+		if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+			return logrus.Fields{"tenant": tenant, "user_id": "42"}
+		}
+		return nil
+	})
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		logger.Hooks.Add(hook)
+
+		entry := logger.WithField("k", "v")
+		entry.WithContext(context.WithValue(context.Background(), tenantKey{}, "acme")).Error(message)
+
+		packet := <-pch
+		if packet.Extra["tenant"] != "acme" || packet.Extra["k"] != "v" {
+			t.Errorf("the fields of the context should have been added to extra, was %+v", packet.Extra)
+		}
+		if packet.User.ID != "42" {
+			t.Errorf("the user of the context should have been sent, was %+v", packet.User)
+		}
+		if _, ok := entry.Data["tenant"]; ok {
+			t.Error("the fields of the entry should be left untouched")
+		}
+	})
+}
+
 func TestSentryFingerprint(t *testing.T) {
 	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
 		logger := getTestLogger()
@@ -296,16 +551,18 @@ func TestSentryStacktrace(t *testing.T) {
 		}
 
 		hook.StacktraceConfiguration.Enable = true
+		hook.StacktraceConfiguration.InAppPrefixes = nil
 
+		_, _, expectedLineno, _ := runtime.Caller(0)
 		logger.Error(message) // this is the call that the last frame of stacktrace should capture
-		expectedLineno := 300 //this should be the line number of the previous line
+		expectedLineno++
 		packet = <-pch
 		stacktraceSize = len(packet.Stacktrace.Frames)
 		if stacktraceSize == 0 {
-			t.Error("Stacktrace should not be empty")
+			t.Fatal("Stacktrace should not be empty")
 		}
-		lastFrame := packet.Stacktrace.Frames[stacktraceSize-2]
-		expectedSuffix := "github.com/CIP-NL/logrus-hooks/sentry/hook_test.go"
+		lastFrame := packet.Stacktrace.Frames[stacktraceSize-1]
+		expectedSuffix := "sentry/hook_test.go"
 		if !strings.HasSuffix(lastFrame.Filename, expectedSuffix) {
 			t.Errorf("File name should have ended with %s, was %s", expectedSuffix, lastFrame.Filename)
 		}
@@ -315,28 +572,44 @@ func TestSentryStacktrace(t *testing.T) {
 		if lastFrame.InApp {
 			t.Error("Frame should not be identified as in_app without prefixes")
 		}
+		if expected := "github.com/CIP-NL/logrus-hooks/sentry.TestSentryStacktrace.func1"; packet.Culprit != expected {
+			t.Errorf("culprit should have been %s, was %s", expected, packet.Culprit)
+		}
 
-		hook.StacktraceConfiguration.InAppPrefixes = []string{"github.com/sirupsen/logrus"}
+		hook.StacktraceConfiguration.InAppPrefixes = []string{"github.com/CIP-NL/logrus-hooks/sentry"}
 		hook.StacktraceConfiguration.Context = 2
-		hook.StacktraceConfiguration.Skip = 2
 
-		logger.Error(message)
+		logger.WithField("k", "v").Error(message)
 		packet = <-pch
 		stacktraceSize = len(packet.Stacktrace.Frames)
 		if stacktraceSize == 0 {
-			t.Error("Stacktrace should not be empty")
+			t.Fatal("Stacktrace should not be empty")
 		}
 		lastFrame = packet.Stacktrace.Frames[stacktraceSize-1]
-		expectedFilename := "github.com/CIP-NL/logrus-hooks/vendor/github.com/sirupsen/logrus/entry.go"
-		if lastFrame.Filename != expectedFilename {
-			t.Errorf("File name should have been %s, was %s", expectedFilename, lastFrame.Filename)
+		if !strings.HasSuffix(lastFrame.Filename, expectedSuffix) {
+			t.Errorf("Entry methods should not be the call site, was %s:%d", lastFrame.Filename, lastFrame.Lineno)
+		}
+		if !lastFrame.InApp {
+			t.Error("Frame should be identified as in_app")
+		}
+		if lastFrame.ContextLine == "" || len(lastFrame.PreContext) != 2 {
+			t.Error("Frame should have context lines")
 		}
 
+		logger.ReportCaller = true
+		logger.Error(message)
+		packet = <-pch
+		lastFrame = packet.Stacktrace.Frames[len(packet.Stacktrace.Frames)-1]
+		if !strings.HasSuffix(lastFrame.Filename, expectedSuffix) {
+			t.Errorf("File name should have ended with %s with ReportCaller, was %s", expectedSuffix, lastFrame.Filename)
+		}
+		logger.ReportCaller = false
+
 		logger.WithError(myStacktracerError{}).Error(message) // use an error that implements Stacktracer
 		packet = <-pch
-		var frames []*raven.StacktraceFrame
-		if packet.Exception.Stacktrace != nil {
-			frames = packet.Exception.Stacktrace.Frames
+		var frames []*Frame
+		if exc := rootException(packet); exc != nil && exc.Stacktrace != nil {
+			frames = exc.Stacktrace.Frames
 		}
 		if len(frames) != 1 || frames[0].Filename != expectedStackFrameFilename {
 			t.Error("Stacktrace should be taken from err if it implements the Stacktracer interface")
@@ -344,8 +617,8 @@ func TestSentryStacktrace(t *testing.T) {
 
 		logger.WithError(pkgerrors.Wrap(myStacktracerError{}, "wrapped")).Error(message) // use an error that wraps a Stacktracer
 		packet = <-pch
-		if packet.Exception.Stacktrace != nil {
-			frames = packet.Exception.Stacktrace.Frames
+		if exc := rootException(packet); exc != nil && exc.Stacktrace != nil {
+			frames = exc.Stacktrace.Frames
 		}
 		expectedCulprit := "wrapped: myStacktracerError!"
 		if packet.Culprit != expectedCulprit {
@@ -357,8 +630,8 @@ func TestSentryStacktrace(t *testing.T) {
 
 		logger.WithError(pkgerrors.New("errorX")).Error(message) // use an error that implements pkgErrorStackTracer
 		packet = <-pch
-		if packet.Exception.Stacktrace != nil {
-			frames = packet.Exception.Stacktrace.Frames
+		if exc := rootException(packet); exc != nil && exc.Stacktrace != nil {
+			frames = exc.Stacktrace.Frames
 		}
 		expectedPkgErrorsStackTraceFilename := "testing/testing.go"
 		expectedFrameCount := 4
@@ -385,6 +658,44 @@ func TestSentryStacktrace(t *testing.T) {
 	})
 }
 
+func TestSwitchExceptionTypeAndMessage(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{logrus.ErrorLevel})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		logger.Hooks.Add(hook)
+		hook.StacktraceConfiguration.Enable = true
+		hook.StacktraceConfiguration.SwitchExceptionTypeAndMessage = true
+
+		hook.StacktraceConfiguration.SendExceptionType = true
+		logger.WithError(errors.New("plain")).Error(message)
+		packet := <-pch
+		if expected := "*errors.errorString: "; !strings.HasPrefix(packet.Culprit, expected) {
+			t.Errorf("culprit should have started with %s, was %s", expected, packet.Culprit)
+		}
+
+		hook.StacktraceConfiguration.SendExceptionType = false
+		logger.WithError(errors.New("plain")).Error(message)
+		packet = <-pch
+		if strings.Contains(packet.Culprit, "errorString") {
+			t.Errorf("culprit should not contain the exception type, was %s", packet.Culprit)
+		}
+		if packet.Culprit == "" {
+			t.Error("culprit should not be empty")
+		}
+	})
+}
+
+// rootException returns the innermost exception of the packet.
+func rootException(packet *resultPacket) *Exception {
+	if len(packet.Exception.Values) == 0 {
+		return nil
+	}
+	return packet.Exception.Values[0]
+}
+
 func TestAddIgnore(t *testing.T) {
 	hook := Hook{
 		ignoreFields: make(map[string]struct{}),
@@ -439,6 +750,56 @@ func TestAddExtraFilter(t *testing.T) {
 	}
 }
 
+func TestBeforeSend(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.SetRelease("app@v1.2.0")
+		hook.SetEnvironment("staging")
+		hook.client.Tags = map[string]string{"site": "test"}
+		hook.BeforeSend = func(entry *logrus.Entry, packet *Event) *Event {
+			if entry.Data["drop"] == true {
+				return nil
+			}
+			if packet.Release != "app@v1.2.0" || packet.Environment != "staging" || packet.Tags["site"] != "test" || packet.EventID == "" {
+				t.Errorf("the event should be complete before BeforeSend, was %+v", packet)
+			}
+			packet.Release = "app@v1.2.1"
+			delete(packet.Tags, "site")
+			packet.Message = "rewritten: " + entry.Message
+			packet.Fingerprint = []string{entry.Data["tenant"].(string)}
+			packet.Culprit = "billing"
+			return packet
+		}
+		logger.Hooks.Add(hook)
+
+		logger.WithField("drop", true).Error(message)
+		logger.WithField("tenant", "acme").Error(message)
+
+		packet := <-pch
+		if packet.Message != "rewritten: "+message {
+			t.Errorf("message should have been rewritten, was %s", packet.Message)
+		}
+		if !reflect.DeepEqual(packet.Fingerprint, []string{"acme"}) {
+			t.Errorf("unexpected fingerprint %v", packet.Fingerprint)
+		}
+		if packet.Culprit != "billing" {
+			t.Errorf("culprit should have been billing, was %s", packet.Culprit)
+		}
+		if packet.Release != "app@v1.2.1" || len(packet.Tags) != 0 {
+			t.Errorf("the changes of BeforeSend should be kept, got release %s and tags %v", packet.Release, packet.Tags)
+		}
+		if s := hook.Stats(); s.Dropped != 1 || s.Sent != 1 {
+			t.Errorf("unexpected stats: %+v", s)
+		}
+	})
+}
+
 func TestFormatExtraData(t *testing.T) {
 	hook := Hook{
 		ignoreFields: make(map[string]struct{}),
@@ -550,9 +911,9 @@ func (myStacktracerError) Error() string { return "myStacktracerError!" }
 
 const expectedStackFrameFilename = "errorFile.go"
 
-func (myStacktracerError) GetStacktrace() *raven.Stacktrace {
-	return &raven.Stacktrace{
-		Frames: []*raven.StacktraceFrame{
+func (myStacktracerError) GetStacktrace() *Stacktrace {
+	return &Stacktrace{
+		Frames: []*Frame{
 			{Filename: expectedStackFrameFilename},
 		},
 	}
@@ -560,16 +921,64 @@ func (myStacktracerError) GetStacktrace() *raven.Stacktrace {
 
 func TestConvertStackTrace(t *testing.T) {
 	hook := Hook{}
-	expected := raven.NewStacktrace(0, 0, nil)
+	hook.StacktraceConfiguration.InAppPrefixes = []string{"github.com/CIP-NL/logrus-hooks/sentry"}
+	_, _, line, _ := runtime.Caller(0)
 	st := pkgerrors.New("-").(pkgErrorStackTracer).StackTrace()
-	ravenSt := hook.convertStackTrace(st)
+	frames := hook.convertStackTrace(st).Frames
+	if len(frames) != len(st)-1 {
+		t.Fatalf("expected %d frames without runtime.goexit, got %d", len(st)-1, len(frames))
+	}
+
+	last := frames[len(frames)-1]
+	expected := Frame{
+		Filename:     last.Filename,
+		AbsolutePath: last.AbsolutePath,
+		Module:       "github.com/CIP-NL/logrus-hooks/sentry",
+		Function:     "TestConvertStackTrace",
+		Lineno:       line + 1,
+		InApp:        true,
+	}
+	if !reflect.DeepEqual(*last, expected) {
+		t.Errorf("the last frame should have been %+v, was %+v", expected, *last)
+	}
+	if !strings.HasSuffix(last.Filename, "sentry/hook_test.go") {
+		t.Errorf("unexpected file name %s", last.Filename)
+	}
+	if first := frames[0]; first.InApp || first.Module != "testing" {
+		t.Errorf("the oldest frame should come first, was %+v", *first)
+	}
+}
 
-	// Obscure the line numbes, so DeepEqual doesn't fail erroneously
-	for _, frame := range append(expected.Frames, ravenSt.Frames...) {
-		frame.Lineno = 999
+func TestInApp(t *testing.T) {
+	prefixes := []string{"example.com/app", "example.com/lib/"}
+	for module, expected := range map[string]bool{
+		"example.com/app":                          true,
+		"example.com/app/db":                       true,
+		"example.com/lib/cache":                    true,
+		"example.com/application":                  false,
+		"example.com/app-tools":                    false,
+		"example.com/app/vendor/github.com/x/y":    false,
+		"example.com/app/third_party/z":            false,
+		"example.com/app/vendorutil":               true,
+		"example.com/app/internal/third_partyness": true,
+		"github.com/x/y":                           false,
+	} {
+		if got := inApp(module, prefixes); got != expected {
+			t.Errorf("inApp(%q) = %t, want %t", module, got, expected)
+		}
 	}
-	if !reflect.DeepEqual(ravenSt, expected) {
-		t.Error("stack traces differ")
+}
+
+func TestSplitFunctionName(t *testing.T) {
+	for name, expected := range map[string][2]string{
+		"main.main":             {"main", "main"},
+		"github.com/a/b.(*T).m": {"github.com/a/b", "(*T).m"},
+		"github.com/CIP-NL/logrus-hooks/sentry.F": {"github.com/CIP-NL/logrus-hooks/sentry", "F"},
+	} {
+		pack, function := splitFunctionName(name)
+		if pack != expected[0] || function != expected[1] {
+			t.Errorf("splitFunctionName(%q) = %q, %q, want %q, %q", name, pack, function, expected[0], expected[1])
+		}
 	}
 }
 
@@ -580,3 +989,70 @@ func TestLogAttempt(t *testing.T) {
 		logAttempt(DSN)
 	}
 }
+
+func TestChainedExceptions(t *testing.T) {
+	WithTestDSN(t, func(dsn string, pch <-chan *resultPacket) {
+		logger := getTestLogger()
+		hook, err := NewHook(dsn, []logrus.Level{
+			logrus.ErrorLevel,
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		hook.StacktraceConfiguration.Enable = true
+		logger.Hooks.Add(hook)
+
+		root := pkgerrors.New("root")
+		chained := fmt.Errorf("outer: %w", errors.Join(fmt.Errorf("middle: %w", root), myStacktracerError{}))
+		logger.WithError(chained).Error(message)
+
+		packet := <-pch
+		values := packet.Exception.Values
+		var msgs []string
+		for _, exc := range values {
+			msgs = append(msgs, exc.Value)
+		}
+		expected := []string{
+			"myStacktracerError!",
+			"root",
+			"middle: root",
+			"middle: root\nmyStacktracerError!",
+			"outer: middle: root\nmyStacktracerError!",
+		}
+		if !reflect.DeepEqual(msgs, expected) {
+			t.Fatalf("expected exceptions %q, got %q", expected, msgs)
+		}
+		if values[0].Type != "sentry.myStacktracerError" {
+			t.Errorf("unexpected type %q", values[0].Type)
+		}
+		if values[0].Stacktrace == nil || values[0].Stacktrace.Frames[0].Filename != expectedStackFrameFilename {
+			t.Error("the Stacktracer error should carry its own stack trace")
+		}
+		if values[1].Stacktrace == nil || len(values[1].Stacktrace.Frames) == 0 {
+			t.Error("the pkg/errors error should carry its own stack trace")
+		}
+		if values[4].Stacktrace != nil {
+			t.Error("the outer error has no stack trace of its own")
+		}
+		if packet.Culprit != chained.Error() {
+			t.Errorf("expected culprit %q, got %q", chained.Error(), packet.Culprit)
+		}
+	})
+}
+
+func TestDefaultInAppPrefixes(t *testing.T) {
+	hook, err := NewHook("", []logrus.Level{logrus.ErrorLevel})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	module := buildinfo.MainModule()
+	if module == "" {
+		if len(hook.StacktraceConfiguration.InAppPrefixes) != 0 {
+			t.Errorf("no prefixes expected without a main module, got %v", hook.StacktraceConfiguration.InAppPrefixes)
+		}
+		return
+	}
+	if p := hook.StacktraceConfiguration.InAppPrefixes; len(p) != 1 || p[0] != module {
+		t.Errorf("in app prefixes should default to [%s], were %v", module, p)
+	}
+}
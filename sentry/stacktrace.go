@@ -0,0 +1,144 @@
+package sentry
+
+import (
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Adapted from the stack trace support of github.com/getsentry/raven-go,
+// which carries this notice:
+//
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+// Some code from the runtime/debug package of the Go standard library.
+
+// newFrame builds the frame of function at file:line, or returns nil for
+// frames that carry no information.
+//
+// context is the number of lines sent around the line of the frame; -1
+// sends the line alone and 0 none. Frames of the main package and of
+// packages matching one of appPackagePrefixes are in app, see inApp.
+func newFrame(function, file string, line, context int, appPackagePrefixes []string) *Frame {
+	frame := &Frame{AbsolutePath: file, Filename: trimPath(file), Lineno: line}
+	frame.Module, frame.Function = splitFunctionName(function)
+
+	// runtime.goexit is a placeholder from runtime/asm_*.s and is meaningless
+	if frame.Module == "runtime" && frame.Function == "goexit" {
+		return nil
+	}
+
+	frame.InApp = frame.Module == "main" || inApp(frame.Module, appPackagePrefixes)
+
+	if context > 0 {
+		lines, idx := fileContext(file, line, context)
+		for i, l := range lines {
+			switch {
+			case i < idx:
+				frame.PreContext = append(frame.PreContext, string(l))
+			case i == idx:
+				frame.ContextLine = string(l)
+			default:
+				frame.PostContext = append(frame.PostContext, string(l))
+			}
+		}
+	} else if context == -1 {
+		if lines, _ := fileContext(file, line, 0); len(lines) > 0 {
+			frame.ContextLine = string(lines[0])
+		}
+	}
+	return frame
+}
+
+// inApp reports whether module is one of prefixes or a package below one,
+// e.g. "example.com/app/db" for the prefix "example.com/app" but not
+// "example.com/application". Packages in a vendor or third_party directory
+// are never in app.
+func inApp(module string, prefixes []string) bool {
+	segments := "/" + module + "/"
+	if strings.Contains(segments, "/vendor/") || strings.Contains(segments, "/third_party/") {
+		return false
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if module == prefix || strings.HasPrefix(module, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitFunctionName splits the fully qualified name of a function into its
+// package path and its name, e.g. "github.com/a/b.(*T).m" into
+// "github.com/a/b" and "(*T).m".
+func splitFunctionName(name string) (pack, function string) {
+	function = name
+	if i := strings.LastIndex(function, "/"); i >= 0 {
+		pack = function[:i+1]
+		function = function[i+1:]
+	}
+	if i := strings.Index(function, "."); i >= 0 {
+		pack += function[:i]
+		function = function[i+1:]
+	}
+	return pack, strings.Replace(function, "·", ".", -1)
+}
+
+var (
+	fileCacheMu sync.Mutex
+	fileCache   = make(map[string][][]byte)
+)
+
+// fileContext returns the lines around line of filename and the index of
+// line among them. Unreadable files are remembered and yield no lines.
+func fileContext(filename string, line, context int) ([][]byte, int) {
+	fileCacheMu.Lock()
+	defer fileCacheMu.Unlock()
+	lines, ok := fileCache[filename]
+	if !ok {
+		if data, err := ioutil.ReadFile(filename); err == nil {
+			lines = bytes.Split(data, []byte{'\n'})
+		}
+		fileCache[filename] = lines
+	}
+
+	line-- // stack trace lines are 1-indexed
+	if line < 0 || line >= len(lines) {
+		return nil, 0
+	}
+	start, idx := line-context, context
+	if start < 0 {
+		start, idx = 0, line
+	}
+	end := line + context + 1
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return lines[start:end], idx
+}
+
+// trimPaths are the source directories trimmed off file names.
+var trimPaths []string
+
+func init() {
+	for _, prefix := range build.Default.SrcDirs() {
+		if prefix[len(prefix)-1] != filepath.Separator {
+			prefix += string(filepath.Separator)
+		}
+		trimPaths = append(trimPaths, prefix)
+	}
+}
+
+// trimPath trims the GOROOT or GOPATH source directory off filename.
+func trimPath(filename string) string {
+	for _, prefix := range trimPaths {
+		if trimmed := strings.TrimPrefix(filename, prefix); len(trimmed) < len(filename) {
+			return trimmed
+		}
+	}
+	return filename
+}
@@ -0,0 +1,115 @@
+package logrus_hooks
+
+import (
+	"sync"
+
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+)
+
+// StatsHook is implemented by hooks that record delivery statistics.
+type StatsHook interface {
+	logrus.Hook
+	Stats() stats.Snapshot
+}
+
+// statsOf returns the statistics of hook, if it records them.
+func statsOf(hook logrus.Hook) (stats.Snapshot, bool) {
+	if h, ok := hook.(StatsHook); ok {
+		return h.Stats(), true
+	}
+	return stats.Snapshot{}, false
+}
+
+// addDropped returns the statistics of hook with the entries dropped by a
+// wrapper around it added.
+func addDropped(hook logrus.Hook, r *stats.Recorder) stats.Snapshot {
+	s, _ := statsOf(hook)
+	s.Dropped += r.Snapshot("").Dropped
+	return s
+}
+
+// capturer is implemented by hooks that fire for more levels than they send
+// events for, e.g. to keep the other entries as breadcrumbs.
+type capturer interface {
+	Captures(level logrus.Level) bool
+}
+
+// captures reports whether hook sends entries of level as events. Hooks that
+// do not implement capturer send every entry they fire for. The wrappers
+// implement it by asking the hook they wrap, so that the answer crosses a
+// chain of them.
+func captures(hook logrus.Hook, level logrus.Level) bool {
+	c, ok := hook.(capturer)
+	return !ok || c.Captures(level)
+}
+
+// passThrough reports whether a wrapper should hand entry to hook without
+// counting it against its limits, because hook will not send it as an event.
+func passThrough(hook logrus.Hook, entry *logrus.Entry) bool {
+	return !captures(hook, entry.Level)
+}
+
+// EntryCounter is a hook counting the entries of a logger per level. One is
+// added to every logger created by GenerateLoggers.
+type EntryCounter struct {
+	// Logger is the name of the logger the entries are counted for.
+	Logger string
+
+	mu     sync.Mutex
+	counts map[logrus.Level]uint64
+}
+
+// Levels returns all levels.
+func (c *EntryCounter) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire counts the entry.
+func (c *EntryCounter) Fire(entry *logrus.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[logrus.Level]uint64)
+	}
+	c.counts[entry.Level]++
+	return nil
+}
+
+// Stats returns the number of entries logged per level.
+func (c *EntryCounter) Stats() map[logrus.Level]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[logrus.Level]uint64, len(c.counts))
+	for lvl, n := range c.counts {
+		counts[lvl] = n
+	}
+	return counts
+}
+
+// LoggerStats returns the entry counts of logger, if it was created by
+// GenerateLoggers.
+func LoggerStats(logger *logrus.Logger) (map[logrus.Level]uint64, bool) {
+	for _, hooks := range logger.Hooks {
+		for _, hook := range hooks {
+			if c, ok := hook.(*EntryCounter); ok {
+				return c.Stats(), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// HookStats returns the statistics of every hook of logger that records
+// them, keyed by hook name.
+func HookStats(logger *logrus.Logger) map[string]stats.Snapshot {
+	snapshots := make(map[string]stats.Snapshot)
+	for _, hooks := range logger.Hooks {
+		for _, hook := range hooks {
+			if s, ok := statsOf(hook); ok {
+				snapshots[s.Hook] = s
+			}
+		}
+	}
+	return snapshots
+}
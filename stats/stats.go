@@ -0,0 +1,170 @@
+// Package stats records dependency-free delivery statistics for hooks.
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the Fire latency
+// histogram.
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Snapshot is a point in time copy of the statistics of a hook.
+type Snapshot struct {
+	// Hook is the name of the hook.
+	Hook string
+	// Sent is the number of events delivered.
+	Sent uint64
+	// Failed is the number of events that could not be delivered.
+	Failed uint64
+	// Dropped is the number of events discarded without a delivery attempt,
+	// e.g. because a queue was full or an entry was sampled out.
+	Dropped uint64
+	// Retried is the number of delivery attempts that were retried.
+	Retried uint64
+	// QueueDepth is the number of events waiting to be sent.
+	QueueDepth int64
+	// InFlight is the number of deliveries in progress.
+	InFlight int64
+	// FireLatency is the time Fire blocks the logging goroutine.
+	FireLatency Histogram
+}
+
+// Histogram is a snapshot of a latency histogram. Counts[i] is the number
+// of observations less than or equal to Buckets[i].
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// Circuit states reported in Health.
+const (
+	CircuitClosed = "closed"
+	CircuitOpen   = "open"
+)
+
+// MaxConsecutiveFailures is the number of consecutive delivery failures
+// after which a hook is considered unhealthy.
+var MaxConsecutiveFailures = 5
+
+// Health is a point in time view of whether a hook is delivering events.
+type Health struct {
+	// Hook is the name of the hook.
+	Hook string `json:"hook"`
+	// LastSuccess is the time of the last delivered event.
+	LastSuccess time.Time `json:"last_success"`
+	// LastFailure is the time of the last failed delivery.
+	LastFailure time.Time `json:"last_failure"`
+	// LastError is the error of the last failed delivery.
+	LastError string `json:"last_error,omitempty"`
+	// ConsecutiveFailures is the number of failures since the last success.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Circuit is CircuitOpen while the hook is not sending events, e.g.
+	// when backing off. Hooks without such a state always report
+	// CircuitClosed.
+	Circuit string `json:"circuit"`
+	// QueueSaturation is the fraction of the hook's queue in use, between
+	// 0 and 1. Synchronous hooks always report 0.
+	QueueSaturation float64 `json:"queue_saturation"`
+}
+
+// Healthy reports whether the hook is delivering events: its circuit is
+// closed, its queue is not full and it did not fail MaxConsecutiveFailures
+// times in a row.
+func (h Health) Healthy() bool {
+	return h.Circuit != CircuitOpen &&
+		h.QueueSaturation < 1 &&
+		h.ConsecutiveFailures < MaxConsecutiveFailures
+}
+
+// Recorder records the statistics of a hook. The zero value is ready to
+// use and all methods are safe for concurrent use.
+type Recorder struct {
+	mu       sync.Mutex
+	snapshot Snapshot
+	health   Health
+}
+
+// Sent records a delivered event.
+func (r *Recorder) Sent() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Sent++
+	r.health.LastSuccess = time.Now()
+	r.health.ConsecutiveFailures = 0
+}
+
+// Failed records an event that could not be delivered because of err.
+func (r *Recorder) Failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot.Failed++
+	r.health.LastFailure = time.Now()
+	r.health.ConsecutiveFailures++
+	if err != nil {
+		r.health.LastError = err.Error()
+	}
+}
+
+// Dropped records an event discarded without a delivery attempt.
+func (r *Recorder) Dropped() { r.update(func(s *Snapshot) { s.Dropped++ }) }
+
+// Retried records a retried delivery attempt.
+func (r *Recorder) Retried() { r.update(func(s *Snapshot) { s.Retried++ }) }
+
+// AddQueueDepth adds delta to the number of queued events.
+func (r *Recorder) AddQueueDepth(delta int64) { r.update(func(s *Snapshot) { s.QueueDepth += delta }) }
+
+// AddInFlight adds delta to the number of deliveries in progress.
+func (r *Recorder) AddInFlight(delta int64) { r.update(func(s *Snapshot) { s.InFlight += delta }) }
+
+// ObserveFire records the time a call to Fire took.
+func (r *Recorder) ObserveFire(d time.Duration) {
+	seconds := d.Seconds()
+	r.update(func(s *Snapshot) {
+		h := &s.FireLatency
+		if h.Counts == nil {
+			h.Buckets = LatencyBuckets
+			h.Counts = make([]uint64, len(LatencyBuckets))
+		}
+		for i, b := range h.Buckets {
+			if seconds <= b {
+				h.Counts[i]++
+			}
+		}
+		h.Count++
+		h.Sum += seconds
+	})
+}
+
+// Snapshot returns the current statistics for the hook named name.
+func (r *Recorder) Snapshot(name string) Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.snapshot
+	s.Hook = name
+	s.FireLatency.Buckets = LatencyBuckets
+	s.FireLatency.Counts = make([]uint64, len(LatencyBuckets))
+	copy(s.FireLatency.Counts, r.snapshot.FireLatency.Counts)
+	return s
+}
+
+// Health returns the current health of the hook named name. The circuit is
+// reported closed and queue saturation is left at 0; hooks fill those in.
+func (r *Recorder) Health(name string) Health {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.health
+	h.Hook = name
+	h.Circuit = CircuitClosed
+	return h
+}
+
+func (r *Recorder) update(fn func(s *Snapshot)) {
+	r.mu.Lock()
+	fn(&r.snapshot)
+	r.mu.Unlock()
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	r := &Recorder{}
+	r.Sent()
+	r.Sent()
+	r.Failed(errors.New("boom"))
+	r.Dropped()
+	r.AddInFlight(2)
+	r.AddInFlight(-1)
+	r.ObserveFire(2 * time.Millisecond)
+	r.ObserveFire(time.Second)
+
+	s := r.Snapshot("sentry")
+	assert.Equal(t, "sentry", s.Hook)
+	assert.Equal(t, uint64(2), s.Sent)
+	assert.Equal(t, uint64(1), s.Failed)
+	assert.Equal(t, uint64(1), s.Dropped)
+	assert.Equal(t, int64(1), s.InFlight)
+	assert.Equal(t, uint64(2), s.FireLatency.Count)
+	assert.InDelta(t, 1.002, s.FireLatency.Sum, 1e-9)
+
+	// 2ms falls in the .0025 bucket and every bucket after it
+	for i, b := range s.FireLatency.Buckets {
+		var expected uint64
+		if b >= .0025 {
+			expected = 1
+		}
+		if b >= 1 {
+			expected = 2
+		}
+		assert.Equal(t, expected, s.FireLatency.Counts[i], "bucket %v", b)
+	}
+
+	// snapshots must not share state with the recorder
+	s.FireLatency.Counts[0] = 99
+	assert.Equal(t, uint64(0), r.Snapshot("").FireLatency.Counts[0])
+}
+
+func TestRecorderHealth(t *testing.T) {
+	r := &Recorder{}
+	h := r.Health("sentry")
+	assert.Equal(t, "sentry", h.Hook)
+	assert.Equal(t, CircuitClosed, h.Circuit)
+	assert.True(t, h.Healthy())
+
+	for i := 0; i < MaxConsecutiveFailures; i++ {
+		r.Failed(errors.New("boom"))
+	}
+	h = r.Health("sentry")
+	assert.Equal(t, MaxConsecutiveFailures, h.ConsecutiveFailures)
+	assert.Equal(t, "boom", h.LastError)
+	assert.False(t, h.LastFailure.IsZero())
+	assert.False(t, h.Healthy())
+
+	r.Sent()
+	h = r.Health("sentry")
+	assert.Equal(t, 0, h.ConsecutiveFailures)
+	assert.False(t, h.LastSuccess.IsZero())
+	assert.True(t, h.Healthy())
+
+	assert.False(t, Health{Circuit: CircuitOpen}.Healthy())
+	assert.False(t, Health{QueueSaturation: 1}.Healthy())
+}
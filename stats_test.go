@@ -0,0 +1,61 @@
+package logrus_hooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CIP-NL/logrus-hooks/stats"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// statsRecordingHook is a recordingHook that reports delivery statistics.
+type statsRecordingHook struct {
+	recordingHook
+	name string
+}
+
+func (h *statsRecordingHook) Stats() stats.Snapshot {
+	return stats.Snapshot{Hook: h.name, Sent: uint64(len(h.all()))}
+}
+
+func TestWrapperChainPassesThroughBreadcrumbs(t *testing.T) {
+	rec := &capturingHook{}
+	redactor, err := NewRedactor(RedactMask, DefaultRedactKeys, DefaultRedactValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := NewRateLimitHook(redactor.Wrap(rec), 1, 1, "")
+	hook := NewSampleHook(NewDedupHook(limit, time.Hour), map[logrus.Level]float64{logrus.DebugLevel: 0}, "")
+	log := newTestLogger(hook)
+	log.SetLevel(logrus.DebugLevel)
+
+	log.Debug("breadcrumb")
+	log.Debug("breadcrumb")
+	log.Error("boom")
+
+	assert.Len(t, rec.all(), 3, "breadcrumbs should pass every wrapper of the chain")
+	assert.Equal(t, uint64(0), hook.Stats().Dropped)
+	assert.False(t, hook.Captures(logrus.DebugLevel))
+	assert.True(t, hook.Captures(logrus.ErrorLevel))
+}
+
+func TestWrapperStats(t *testing.T) {
+	rec := &statsRecordingHook{name: "sentry"}
+	hook := NewDedupHook(rec, time.Hour)
+	log := newTestLogger(hook)
+	log.AddHook(&EntryCounter{Logger: "api_logger"})
+
+	log.Error("boom")
+	log.Error("boom")
+	log.Error("boom")
+	log.Warn("careful")
+
+	s := HookStats(log)["sentry"]
+	assert.Equal(t, uint64(2), s.Sent)
+	assert.Equal(t, uint64(2), s.Dropped)
+
+	counts, ok := LoggerStats(log)
+	assert.True(t, ok)
+	assert.Equal(t, map[logrus.Level]uint64{logrus.ErrorLevel: 3, logrus.WarnLevel: 1}, counts)
+}
@@ -0,0 +1,47 @@
+// Package tracing correlates log entries with OpenTelemetry traces. The hooks
+// read the IDs of an entry with IDs and send them along with its event, so
+// that the event links to its trace.
+package tracing
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fields holding the IDs of the trace and span an entry was logged in.
+const (
+	FieldTraceID = "trace_id"
+	FieldSpanID  = "span_id"
+)
+
+// WithContext returns a copy of entry for ctx, see logrus.Entry.WithContext,
+// carrying the IDs of the span active in ctx, if any, as fields. The fields
+// keep the IDs in the output of formatters and in wrappers that key off
+// fields, such as the sample hook.
+func WithContext(entry *logrus.Entry, ctx context.Context) *logrus.Entry {
+	entry = entry.WithFields(logrus.Fields{}).WithContext(ctx)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return entry
+	}
+	return entry.WithFields(logrus.Fields{
+		FieldTraceID: sc.TraceID().String(),
+		FieldSpanID:  sc.SpanID().String(),
+	})
+}
+
+// IDs returns the trace and span IDs of entry, as lower case hex strings.
+// They are those of the span active in entry.Context, or else the values of
+// the trace_id and span_id fields. ok is false when entry has no trace ID.
+func IDs(entry *logrus.Entry) (traceID, spanID string, ok bool) {
+	if entry.Context != nil {
+		if sc := trace.SpanContextFromContext(entry.Context); sc.IsValid() {
+			return sc.TraceID().String(), sc.SpanID().String(), true
+		}
+	}
+	traceID, _ = entry.Data[FieldTraceID].(string)
+	spanID, _ = entry.Data[FieldSpanID].(string)
+	return traceID, spanID, traceID != ""
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceID = "0102030405060708090a0b0c0d0e0f10"
+	spanID  = "0102030405060708"
+)
+
+// spanContext returns a context with an active span with the test IDs.
+func spanContext(t *testing.T) context.Context {
+	tid, err := trace.TraceIDFromHex(traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid, err := trace.SpanIDFromHex(spanID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func newEntry() *logrus.Entry {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return logrus.NewEntry(logger).WithField("k", "v")
+}
+
+func TestWithContext(t *testing.T) {
+	parent := newEntry()
+	ctx := spanContext(t)
+	entry := WithContext(parent, ctx)
+
+	assert.Equal(t, ctx, entry.Context)
+	assert.Equal(t, traceID, entry.Data[FieldTraceID])
+	assert.Equal(t, spanID, entry.Data[FieldSpanID])
+	assert.Equal(t, "v", entry.Data["k"])
+	assert.NotContains(t, parent.Data, FieldTraceID, "the parent entry should be left untouched")
+
+	entry = WithContext(parent, context.Background())
+	assert.NotContains(t, entry.Data, FieldTraceID)
+	entry.Data["x"] = 1
+	assert.NotContains(t, parent.Data, "x", "the entries should not share their data")
+}
+
+func TestIDs(t *testing.T) {
+	entry := newEntry()
+	_, _, ok := IDs(entry)
+	assert.False(t, ok)
+
+	tid, sid, ok := IDs(entry.WithContext(spanContext(t)))
+	assert.True(t, ok)
+	assert.Equal(t, traceID, tid)
+	assert.Equal(t, spanID, sid)
+
+	tid, sid, ok = IDs(entry.WithField(FieldTraceID, "abc"))
+	assert.True(t, ok)
+	assert.Equal(t, "abc", tid)
+	assert.Equal(t, "", sid)
+
+	tid, _, _ = IDs(entry.WithField(FieldTraceID, "abc").WithContext(spanContext(t)))
+	assert.Equal(t, traceID, tid, "the active span should win over the fields")
+}